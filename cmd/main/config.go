@@ -3,6 +3,8 @@ package main
 import (
 	"github.com/BurntSushi/toml"
 	"github.com/goph/emperror"
+	"github.com/je4/bagarc/v2/pkg/bagit"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
 	"net"
 	"path/filepath"
 	"strconv"
@@ -63,34 +65,101 @@ type FileMap struct {
 	Folder string
 }
 
+// files below Prefix are not stored but referenced in fetch.txt with URL
+type External struct {
+	Prefix string
+	URL    string
+}
+
+// rules for renaming of filenames. custom rules are applied after the preset
+type FilenameRules struct {
+	Preset          string
+	Name            string
+	Version         string
+	Rule            []sanitize.Rule
+	Collisions      string // fail, number or hash
+	CaseInsensitive bool   // collision, if names differ only in case
+	Normalization   string // unicode normalization nfc, nfd or none
+}
+
 type Indexer struct {
-	Url    string
-	Checks []string
+	Url         string
+	Checks      []string
+	Timeout     duration // per request
+	Retries     int      // after network errors, timeouts and 5xx responses
+	Concurrency int      // parallel requests
 }
 
 // main config structure for toml file
 type BagitConfig struct {
-	CertChain      string               `toml:"*certchain"`
-	PrivateKey     []string             `toml:"privatekey"`
-	Listen         string               `toml:"listen"`
-	TLS            bool                 `toml:"tls"`
-	AccessLog      string               `toml:"accesslog"`
-	Logfile        string               `toml:"logfile"`
-	Loglevel       string               `toml:"loglevel"`
-	Logformat      string               `toml:"logformat"`
-	Checksum       []string             `toml:"checksum"`
-	Tempdir        string               `toml:"tempdir"`
-	KeyDir         string               `toml:"keydir"`
-	Indexer        Indexer              `toml:"indexer"`
-	FixFilenames   bool                 `toml:"fixfilenames"`
-	StoreOnly      []string             `toml:"nocompress"`
-	Cleanup        bool                 `toml:"cleanup"`
-	DBFolder       string               `toml:"dbfolder"`
-	BaseDir        string               `toml:"basedir"`
-	Tunnel         map[string]SSHTunnel `toml:"tunnel"`
-	DB             DBMySQL              `toml:"db"`
-	IngestLocation string               `toml:"ingestloc"`
-	FileMap        []FileMap            `toml:"filemap"`
+	CertChain          string                  `toml:"*certchain"`
+	PrivateKey         []string                `toml:"privatekey"`
+	Listen             string                  `toml:"listen"`
+	TLS                bool                    `toml:"tls"`
+	AccessLog          string                  `toml:"accesslog"`
+	Logfile            string                  `toml:"logfile"`
+	Loglevel           string                  `toml:"loglevel"`
+	Logformat          string                  `toml:"logformat"`
+	Checksum           []string                `toml:"checksum"`
+	BagitVersion       string                  `toml:"bagitversion"`
+	CheckPolicy        string                  `toml:"checkpolicy"`
+	FetchTimeout       duration                `toml:"fetchtimeout"`
+	Workers            int                     `toml:"workers"`
+	Tempdir            string                  `toml:"tempdir"`
+	KeyDir             string                  `toml:"keydir"`
+	Indexer            Indexer                 `toml:"indexer"`
+	Identify           bool                    `toml:"identify"`
+	FixFilenames       bool                    `toml:"fixfilenames"`
+	FilenamePolicy     string                  `toml:"filenamepolicy"`
+	FilenameRules      FilenameRules           `toml:"filenamerules"`
+	Xattrs             bool                    `toml:"xattrs"`
+	Symlinks           string                  `toml:"symlinks"`
+	EmptyDirs          bool                    `toml:"emptydirs"`
+	Include            []string                `toml:"include"`
+	Exclude            []string                `toml:"exclude"`
+	MaxDepth           int                     `toml:"maxdepth"`
+	MaxSize            string                  `toml:"maxsize"`
+	StoreOnly          []string                `toml:"nocompress"`
+	Compression        []bagit.CompressionRule `toml:"compression"`
+	MinCompressionGain float64                 `toml:"mincompressiongain"`
+	Cleanup            bool                    `toml:"cleanup"`
+	DBFolder           string                  `toml:"dbfolder"`
+	BaseDir            string                  `toml:"basedir"`
+	Tunnel             map[string]SSHTunnel    `toml:"tunnel"`
+	DB                 DBMySQL                 `toml:"db"`
+	IngestLocation     string                  `toml:"ingestloc"`
+	FileMap            []FileMap               `toml:"filemap"`
+	External           []External              `toml:"external"`
+}
+
+// RuleSet creates the rule set of preset and custom rules
+func (fr FilenameRules) RuleSet() (*sanitize.RuleSet, error) {
+	preset := fr.Preset
+	if preset == "" && len(fr.Rule) == 0 {
+		preset = sanitize.Wheeler
+	}
+	rules := []sanitize.Rule{}
+	name, version := fr.Name, fr.Version
+	if preset != "" {
+		rs, err := sanitize.Preset(preset)
+		if err != nil {
+			return nil, err
+		}
+		if len(fr.Rule) == 0 {
+			return rs, nil
+		}
+		rules = rs.Rules()
+		if name == "" {
+			name = rs.Name() + "+custom"
+		}
+	}
+	if name == "" {
+		name = "custom"
+	}
+	if version == "" {
+		version = "1"
+	}
+	return sanitize.NewRuleSet(name, version, append(rules, fr.Rule...))
 }
 
 func LoadBagitConfig(fp string, conf *BagitConfig) error {
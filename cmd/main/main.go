@@ -6,42 +6,78 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/dgraph-io/badger"
 	_ "github.com/dgraph-io/badger"
+	"github.com/dustin/go-humanize"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/je4/bagarc/v2/pkg/bagit"
+	"github.com/je4/bagarc/v2/pkg/indexer"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
 	"github.com/je4/sshtunnel/v2/pkg/sshtunnel"
+	"github.com/op/go-logging"
 	flag "github.com/spf13/pflag"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 )
 
 func main() {
-	var action = flag.String("action", "bagit", "bagit|bagitcheck|ingest")
+	var action = flag.String("action", "bagit", "bagit|update|plan|check|extract|complete")
 	var sourcedir = flag.String("sourcedir", ".", "source folder with archive content")
 	var basedir = flag.String("basedir", ".", "base folder with archived bagit's")
-	var bagitfile = flag.String("bagit", "bagarc.zip", "target filename (bagit zip)")
+	var bagitfile = flag.String("bagit", "bagarc.zip", "target filename (bagit .zip|.tar|.tar.gz|.tar.zst) or folder (unserialized bagit)")
 	var configfile = flag.String("cfg", "/etc/bagit.toml", "configuration file")
 	var tempdir = flag.String("temp", "/tmp", "folder for temporary files")
-	var checksum = flag.StringArray("checksum", []string{"md5", "sha512"}, "checksum algorithms to use (md5|sha1|sha256|sha512)")
-	var indexer = flag.String("indexer", "", "url for indexer")
+	var checksum = flag.StringArray("checksum", []string{}, "checksum algorithms to use (md5|sha1|sha256|sha512) (default md5,sha512 for BagIt 0.97, sha512 for 1.0)")
+	var bagitVersion = flag.String("bagitversion", bagit.BAGITVERSION, "BagIt version of new bags (0.97|1.0)")
+	var indexerUrl = flag.String("indexer", "", "url for indexer")
+	var identify = flag.Bool("identify", true, "identify file formats by signature without indexer and record them in bagarc/metainfo.json")
 	var fixFilenames = flag.Bool("fixfilenames", true, "set this flag, if filenames should be corrected")
+	var collisions = flag.String("collisions", "", "handling of files with the same name after renaming or case folding (fail|number|hash)")
+	var normalization = flag.String("normalize", "", "unicode normalization of filenames (none|nfc|nfd)")
+	var filenamePolicy = flag.String("filenames", "", "handling of problematic filenames (rename|encode|reject), overrides fixfilenames")
 	var bagInfoFile = flag.String("baginfo", "", "json file with bag-info entries (only string, no hierarchy)")
 	var cleanup = flag.Bool("cleanup", false, "remove temporary files after bagit creation")
+	var xattrs = flag.Bool("xattrs", false, "record extended attributes of files in bagarc/metainfo.json")
+	var include = flag.StringArray("include", []string{}, "gitignore style pattern of files to bag (added to config)")
+	var exclude = flag.StringArray("exclude", []string{}, "gitignore style pattern of files not to bag (added to config)")
+	var maxDepth = flag.Int("maxdepth", 0, "maximum folder depth of bagged files (0: unlimited)")
+	var maxSize = flag.String("maxsize", "", "maximum size of bagged files e.g. 2GB")
+	var minCompressionGain = flag.Float64("mincompressiongain", 0, "store files of zip bags, which are not at least this percentage smaller after compression")
+	var symlinks = flag.String("symlinks", "", "handling of symbolic links (follow|record|skip)")
+	var emptyDirs = flag.Bool("emptydirs", false, "record empty directories in bagarc/metainfo.json")
+	var restoreStructure = flag.Bool("restorestructure", false, "recreate symbolic links and empty directories while extracting")
+	var restoreMetadata = flag.Bool("restoremetadata", false, "restore times, permissions, owner and extended attributes while extracting")
 	var restoreFilenames = flag.Bool("restorefilenames", true, "rename strange characters back while extracting")
 	var outputFolder = flag.String("output", ".", "folder in which output structure has to be copied")
 	var force = flag.Bool("force", false, "overwrite existing bagit file")
+	var resume = flag.Bool("resume", false, "continue interrupted bagit creation (zip, tar or folder)")
+	var checkPolicy = flag.String("checkpolicy", "strongest", "manifests to verify (strongest|all|comma separated list of checksums)")
+	var indexDB = flag.Bool("indexdb", false, "keep checksums in a badger database in temp folder instead of memory (huge bags)")
+	var workers = flag.Int("workers", runtime.NumCPU(), "number of parallel workers for checksum verification and bag creation")
+	var reportFormat = flag.String("report", "text", "format of validation report or plan (text|json)")
+	var reportFile = flag.String("reportfile", "", "file for validation report or plan (default: stdout)")
 
 	flag.Parse()
 
 	var conf = &BagitConfig{
-		Logfile:   "",
-		Loglevel:  "DEBUG",
-		Logformat: `%{time:2006-01-02T15:04:05.000} %{module}::%{shortfunc} > %{level:.5s} - %{message}`,
-		Checksum:  []string{"md5", "sha512"},
-		Tempdir:   "/tmp",
+		Logfile:      "",
+		Loglevel:     "DEBUG",
+		Logformat:    `%{time:2006-01-02T15:04:05.000} %{module}::%{shortfunc} > %{level:.5s} - %{message}`,
+		Tempdir:      "/tmp",
+		Workers:      runtime.NumCPU(),
+		BagitVersion: bagit.BAGITVERSION,
+		Identify:     true,
+		FetchTimeout: duration{Duration: bagit.DefaultFetchTimeout},
+		Indexer: Indexer{
+			Timeout:     duration{Duration: indexer.DefaultTimeout},
+			Retries:     indexer.DefaultRetries,
+			Concurrency: indexer.DefaultConcurrency,
+		},
 	}
 	if err := LoadBagitConfig(*configfile, conf); err != nil {
 		log.Printf("cannot load config file: %v", err)
@@ -54,14 +90,44 @@ func main() {
 			conf.Tempdir = *tempdir
 		case "checksum":
 			conf.Checksum = *checksum
+		case "bagitversion":
+			conf.BagitVersion = *bagitVersion
 		case "indexer":
-			conf.Indexer.Url = *indexer
+			conf.Indexer.Url = *indexerUrl
+		case "identify":
+			conf.Identify = *identify
 		case "fixfilenames":
 			conf.FixFilenames = *fixFilenames
+		case "filenames":
+			conf.FilenamePolicy = *filenamePolicy
+		case "collisions":
+			conf.FilenameRules.Collisions = *collisions
+		case "xattrs":
+			conf.Xattrs = *xattrs
+		case "include":
+			conf.Include = append(conf.Include, *include...)
+		case "exclude":
+			conf.Exclude = append(conf.Exclude, *exclude...)
+		case "maxdepth":
+			conf.MaxDepth = *maxDepth
+		case "maxsize":
+			conf.MaxSize = *maxSize
+		case "mincompressiongain":
+			conf.MinCompressionGain = *minCompressionGain
+		case "symlinks":
+			conf.Symlinks = *symlinks
+		case "emptydirs":
+			conf.EmptyDirs = *emptyDirs
+		case "normalize":
+			conf.FilenameRules.Normalization = *normalization
 		case "cleanup":
 			conf.Cleanup = *cleanup
 		case "basedir":
 			conf.BaseDir = *basedir
+		case "checkpolicy":
+			conf.CheckPolicy = *checkPolicy
+		case "workers":
+			conf.Workers = *workers
 		}
 	})
 
@@ -135,75 +201,124 @@ func main() {
 
 	switch *action {
 	case "check":
-		tmpdir, err := ioutil.TempDir(conf.Tempdir, filepath.Base(*bagitfile))
+		index, closeIndex := openChecksumIndex(*indexDB, conf.Tempdir, *bagitfile, logger)
+		defer closeIndex()
+
+		checker, err := bagit.NewBagit(*bagitfile, conf.Tempdir, index, logger)
 		if err != nil {
-			logger.Fatalf("cannot create temporary folder in %s", conf.Tempdir)
+			logger.Fatalf("cannot open bagit: %v", err)
+		}
+		defer checker.Close()
+		if err := checker.SetCheckPolicy(bagit.ParseCheckPolicy(conf.CheckPolicy)); err != nil {
+			logger.Fatalf("invalid check policy %s: %v", conf.CheckPolicy, err)
 		}
-		bconfig := badger.DefaultOptions(filepath.Join(tmpdir, "/badger"))
-		bconfig.Logger = logger // use our logger...
-		db, err := badger.Open(bconfig)
+		checker.SetWorkers(conf.Workers)
+		report, err := checker.Validate(nil, nil)
 		if err != nil {
-			logger.Fatalf("cannot open badger database: %v", err)
+			logger.Fatalf("error checking file: %v", err)
 		}
-		defer func() {
-			db.Close()
-			if err := os.RemoveAll(tmpdir); err != nil {
-				logger.Errorf("cannot remove %s: %v", tmpdir, err)
+		var reportWriter io.Writer = os.Stdout
+		if *reportFile != "" {
+			rf, err := os.Create(*reportFile)
+			if err != nil {
+				logger.Fatalf("cannot create report file %s: %v", *reportFile, err)
 			}
-		}()
-
-		checker, err := bagit.NewBagit(*bagitfile, tmpdir, db, logger)
-		if err := checker.Check(nil, nil); err != nil {
+			defer rf.Close()
+			reportWriter = rf
+		}
+		if err := report.Write(reportWriter, *reportFormat); err != nil {
+			logger.Errorf("cannot write report: %v", err)
+		}
+		if err := report.Err(); err != nil {
 			logger.Fatalf("error checking file: %v", err)
 		}
-	case "extract":
-		tmpdir, err := ioutil.TempDir(conf.Tempdir, filepath.Base(*bagitfile))
+	case "complete":
+		checker, err := bagit.NewBagit(*bagitfile, conf.Tempdir, nil, logger)
 		if err != nil {
-			logger.Fatalf("cannot create temporary folder in %s", conf.Tempdir)
+			logger.Fatalf("cannot open bagit: %v", err)
+		}
+		defer checker.Close()
+		if err := checker.SetCheckPolicy(bagit.ParseCheckPolicy(conf.CheckPolicy)); err != nil {
+			logger.Fatalf("invalid check policy %s: %v", conf.CheckPolicy, err)
 		}
-		bconfig := badger.DefaultOptions(filepath.Join(tmpdir, "/badger"))
-		bconfig.Logger = logger // use our logger...
-		db, err := badger.Open(bconfig)
+		report, err := checker.Complete(conf.Tempdir, &http.Client{Timeout: conf.FetchTimeout.Duration})
 		if err != nil {
-			logger.Fatalf("cannot open badger database: %v", err)
+			logger.Fatalf("error completing bagit: %v", err)
 		}
-		defer func() {
-			db.Close()
-			if err := os.RemoveAll(tmpdir); err != nil {
-				logger.Errorf("cannot remove %s: %v", tmpdir, err)
-			}
-		}()
+		if err := report.Write(os.Stdout, *reportFormat); err != nil {
+			logger.Errorf("cannot write report: %v", err)
+		}
+		if err := report.Err(); err != nil {
+			logger.Fatalf("error completing bagit: %v", err)
+		}
+	case "extract":
+		index, closeIndex := openChecksumIndex(*indexDB, conf.Tempdir, *bagitfile, logger)
+		defer closeIndex()
 
-		checker, err := bagit.NewBagit(*bagitfile, tmpdir, db, logger)
+		checker, err := bagit.NewBagit(*bagitfile, conf.Tempdir, index, logger)
+		if err != nil {
+			logger.Fatalf("cannot open bagit: %v", err)
+		}
+		defer checker.Close()
+		if err := checker.SetCheckPolicy(bagit.ParseCheckPolicy(conf.CheckPolicy)); err != nil {
+			logger.Fatalf("invalid check policy %s: %v", conf.CheckPolicy, err)
+		}
+		checker.SetRestoreMetadata(*restoreMetadata)
+		checker.SetRestoreStructure(*restoreStructure)
 		if err := checker.Extract(*outputFolder, *restoreFilenames); err != nil {
 			logger.Fatalf("error extracting file: %v", err)
 		}
-	case "bagit":
+	case "bagit", "update", "plan":
 		// clean up all files
 		tmpdir := *bagitfile + ".tmp"
-		if !*force {
-			if _, err := os.Stat(*bagitfile); !os.IsNotExist(err) {
-				logger.Fatalf("%s already exists", *bagitfile)
+		var db *badger.DB
+		// a plan writes nothing
+		if *action != "plan" {
+			if *action == "update" {
+				if _, err := os.Stat(*bagitfile); err != nil {
+					logger.Fatalf("cannot update %s: %v", *bagitfile, err)
+				}
+				os.RemoveAll(tmpdir)
+				os.Mkdir(tmpdir, os.ModePerm)
+			} else if *resume {
+				// badger database in tmpdir knows all finished files
+				if _, err := os.Stat(filepath.Join(tmpdir, "badger")); err != nil {
+					logger.Fatalf("cannot resume %s: no database in %s", *bagitfile, tmpdir)
+				}
+			} else {
+				if !*force {
+					if _, err := os.Stat(*bagitfile); !os.IsNotExist(err) {
+						logger.Fatalf("%s already exists", *bagitfile)
+					}
+				}
+				// only folder bags are removed recursively
+				if info, err := os.Stat(*bagitfile); err == nil && info.IsDir() {
+					if _, err := os.Stat(filepath.Join(*bagitfile, "bagit.txt")); err != nil {
+						logger.Fatalf("cannot overwrite %s: folder without bagit.txt", *bagitfile)
+					}
+					os.RemoveAll(*bagitfile)
+				} else {
+					os.Remove(*bagitfile)
+				}
+				os.RemoveAll(tmpdir)
+				os.Mkdir(tmpdir, os.ModePerm)
 			}
-		}
-		os.Remove(*bagitfile)
-		os.RemoveAll(tmpdir)
-		os.Mkdir(tmpdir, os.ModePerm)
 
-		bconfig := badger.DefaultOptions(filepath.Join(tmpdir, "/badger"))
-		bconfig.Logger = logger // use our logger...
-		db, err := badger.Open(bconfig)
-		if err != nil {
-			logger.Fatalf("cannot open badger database: %v", err)
-		}
-		defer func() {
-			db.Close()
-			if conf.Cleanup {
-				if err := os.RemoveAll(tmpdir); err != nil {
-					logger.Errorf("cannot remove %s: %v", tmpdir, err)
-				}
+			bconfig := badger.DefaultOptions(filepath.Join(tmpdir, "/badger"))
+			bconfig.Logger = logger // use our logger...
+			db, err = badger.Open(bconfig)
+			if err != nil {
+				logger.Fatalf("cannot open badger database: %v", err)
 			}
-		}()
+			defer func() {
+				db.Close()
+				if conf.Cleanup {
+					if err := os.RemoveAll(tmpdir); err != nil {
+						logger.Errorf("cannot remove %s: %v", tmpdir, err)
+					}
+				}
+			}()
+		}
 
 		bagInfo := map[string]string{}
 		if *bagInfoFile != "" {
@@ -224,6 +339,100 @@ func main() {
 			logger.Fatalf("cannot create BagitCreator: %v", err)
 			return
 		}
+		fetchRules := []bagit.FetchRule{}
+		for _, ext := range conf.External {
+			fetchRules = append(fetchRules, bagit.FetchRule{Prefix: ext.Prefix, URL: ext.URL})
+		}
+		creator.SetFetchRules(fetchRules)
+		if err := creator.SetVersion(conf.BagitVersion); err != nil {
+			logger.Fatalf("cannot create Bagit: %v", err)
+		}
+		rules, err := conf.FilenameRules.RuleSet()
+		if err != nil {
+			logger.Fatalf("invalid filename rules: %v", err)
+		}
+		creator.SetFilenameRules(rules)
+		resolution := sanitize.ResolveNumber
+		if conf.FilenameRules.Collisions != "" {
+			if resolution, err = sanitize.ParseResolution(conf.FilenameRules.Collisions); err != nil {
+				logger.Fatalf("cannot create Bagit: %v", err)
+			}
+		}
+		creator.SetCollisionResolution(resolution, !conf.FilenameRules.CaseInsensitive)
+		normalize, err := sanitize.ParseNormalization(conf.FilenameRules.Normalization)
+		if err != nil {
+			logger.Fatalf("cannot create Bagit: %v", err)
+		}
+		creator.SetNormalization(normalize)
+		creator.SetXattrs(conf.Xattrs)
+		creator.SetWorkers(conf.Workers)
+		creator.SetEmptyDirs(conf.EmptyDirs)
+		filter, err := bagit.NewFilter(conf.Include, conf.Exclude)
+		if err != nil {
+			logger.Fatalf("invalid include/exclude patterns: %v", err)
+		}
+		creator.SetFilter(filter)
+		creator.SetMaxDepth(conf.MaxDepth)
+		if err := creator.SetCompressionRules(conf.Compression); err != nil {
+			logger.Fatalf("invalid compression rules: %v", err)
+		}
+		creator.SetMinCompressionGain(conf.MinCompressionGain)
+		if conf.Indexer.Url != "" {
+			client := indexer.NewClient(conf.Indexer.Url, conf.Indexer.Concurrency, logger)
+			client.SetTimeout(conf.Indexer.Timeout.Duration)
+			client.SetRetries(conf.Indexer.Retries, indexer.DefaultBackoff, indexer.DefaultMaxBackoff)
+			creator.SetIndexerClient(client)
+		}
+		if conf.Identify {
+			creator.SetIdentifier(bagit.NewMagicIdentifier())
+		}
+		if conf.MaxSize != "" {
+			size, err := humanize.ParseBytes(conf.MaxSize)
+			if err != nil {
+				logger.Fatalf("invalid maximum size %s: %v", conf.MaxSize, err)
+			}
+			creator.SetMaxSize(int64(size))
+		}
+		if conf.Symlinks != "" {
+			linkPolicy, err := bagit.ParseLinkPolicy(conf.Symlinks)
+			if err != nil {
+				logger.Fatalf("cannot create Bagit: %v", err)
+			}
+			creator.SetLinkPolicy(linkPolicy)
+		}
+		if conf.FilenamePolicy != "" {
+			policy, err := bagit.ParseFilenamePolicy(conf.FilenamePolicy)
+			if err != nil {
+				logger.Fatalf("cannot create Bagit: %v", err)
+			}
+			creator.SetFilenamePolicy(policy)
+		}
+		if *action == "plan" {
+			plan, err := creator.Plan()
+			if err != nil {
+				logger.Fatalf("cannot plan Bagit: %v", err)
+			}
+			var reportWriter io.Writer = os.Stdout
+			if *reportFile != "" {
+				rf, err := os.Create(*reportFile)
+				if err != nil {
+					logger.Fatalf("cannot create report file %s: %v", *reportFile, err)
+				}
+				defer rf.Close()
+				reportWriter = rf
+			}
+			if err := plan.Write(reportWriter, *reportFormat); err != nil {
+				logger.Errorf("cannot write plan: %v", err)
+			}
+			break
+		}
+		if *action == "update" {
+			if err := creator.Update(); err != nil {
+				logger.Fatalf("cannot update Bagit: %v", err)
+			}
+			break
+		}
+		creator.SetResume(*resume)
 		if err := creator.Run(); err != nil {
 			logger.Fatalf("cannot create Bagit: %v", err)
 		}
@@ -232,3 +441,26 @@ func main() {
 	}
 
 }
+
+// openChecksumIndex creates a badger based index in a temporary folder if useDB is set, memory index otherwise
+func openChecksumIndex(useDB bool, tempdir, bagitfile string, logger *logging.Logger) (bagit.ChecksumIndex, func()) {
+	if !useDB {
+		return bagit.NewMemoryIndex(), func() {}
+	}
+	tmpdir, err := ioutil.TempDir(tempdir, filepath.Base(bagitfile))
+	if err != nil {
+		logger.Fatalf("cannot create temporary folder in %s", tempdir)
+	}
+	bconfig := badger.DefaultOptions(filepath.Join(tmpdir, "/badger"))
+	bconfig.Logger = logger // use our logger...
+	db, err := badger.Open(bconfig)
+	if err != nil {
+		logger.Fatalf("cannot open badger database: %v", err)
+	}
+	return bagit.NewBadgerIndex(db), func() {
+		db.Close()
+		if err := os.RemoveAll(tmpdir); err != nil {
+			logger.Errorf("cannot remove %s: %v", tmpdir, err)
+		}
+	}
+}
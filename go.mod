@@ -10,9 +10,11 @@ require (
 	github.com/goph/emperror v0.17.2
 	github.com/je4/sshtunnel/v2 v2.0.0-20210324104725-ab38247e5ffa
 	github.com/je4/utils/v2 v2.0.6
+	github.com/klauspost/compress v1.15.9
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+	golang.org/x/sys v0.0.0-20220610221304-9f5ed59c137d
 	golang.org/x/text v0.3.7
 )
 
@@ -24,6 +26,5 @@ require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/net v0.0.0-20220607020251-c690dde0001d // indirect
-	golang.org/x/sys v0.0.0-20220610221304-9f5ed59c137d // indirect
 	google.golang.org/protobuf v1.28.0 // indirect
 )
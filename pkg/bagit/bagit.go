@@ -3,272 +3,468 @@ package bagit
 import (
 	"archive/zip"
 	"bufio"
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
 	"encoding/csv"
 	"errors"
 	"fmt"
-	"github.com/dgraph-io/badger"
 	"github.com/goph/emperror"
 	"github.com/op/go-logging"
-	"golang.org/x/crypto/sha3"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/unicode"
-	"golang.org/x/text/transform"
-	"hash"
+	"golang.org/x/text/unicode/norm"
 	"io"
-	"log"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// CheckPolicy defines which payload manifests are verified
+type CheckPolicy string
+
+const (
+	CheckStrongest CheckPolicy = "strongest" // only the manifest with the strongest checksum
+	CheckAll       CheckPolicy = "all"       // all manifests present in the bag
+	CheckList      CheckPolicy = "list"      // explicit list of checksums
+)
+
 // describes a structure for ingest process
 type Bagit struct {
-	logger    *logging.Logger
-	bagitfile string     // zip to checkManifest
-	db        *badger.DB // file storage
-	tmpdir    string     // folder for temporary files
-	indexer   string
+	logger      *logging.Logger
+	bagitfile   string        // name of bagit for logging and reports
+	fsys        fs.FS         // bag container (folder, zip, ...)
+	closer      io.Closer     // closes container if opened by NewBagit
+	index       ChecksumIndex // storage for calculated checksums
+	indexer     string
+	checkPolicy CheckPolicy // which manifests to verify
+	checkList   []string    // checksums to verify with CheckList policy
+	workers     int         // number of parallel checksum workers
+	metadata    bool        // restore file metadata on extract
+	structure   bool        // restore symbolic links and empty directories on extract
 }
 
-func NewBagit(bagitFile string, tmpdir string, db *badger.DB, logger *logging.Logger) (*Bagit, error) {
+// NewBagit opens a serialized bag (zip, tar, tar.gz, tar.zst) or bagit folder.
+// compressed tars are unpacked to tempdir. if index is nil, checksums are held in memory
+func NewBagit(bagitFile, tempdir string, index ChecksumIndex, logger *logging.Logger) (*Bagit, error) {
+	fsys, closer, err := OpenBagFS(bagitFile, tempdir)
+	if err != nil {
+		return nil, err
+	}
+	checker, err := NewBagitFS(bagitFile, fsys, index, logger)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+	checker.closer = closer
+	return checker, nil
+}
+
+// NewBagitReaderAt creates a checker for an in-memory or remote zip
+func NewBagitReaderAt(name string, reader io.ReaderAt, size int64, index ChecksumIndex, logger *logging.Logger) (*Bagit, error) {
+	zr, err := zip.NewReader(reader, size)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot open zip %v", name)
+	}
+	return NewBagitFS(name, zr, index, logger)
+}
+
+// NewBagitFS creates a checker for a bag within any container, which implements fs.FS
+func NewBagitFS(name string, fsys fs.FS, index ChecksumIndex, logger *logging.Logger) (*Bagit, error) {
+	if index == nil {
+		index = NewMemoryIndex()
+	}
 	checker := &Bagit{
-		logger:    logger,
-		bagitfile: bagitFile,
-		db:        db,
-		tmpdir:    tmpdir,
+		logger:      logger,
+		bagitfile:   name,
+		fsys:        fsys,
+		index:       index,
+		checkPolicy: CheckStrongest,
+		workers:     1,
 	}
 
 	return checker, nil
 }
 
-func (bagit *Bagit) checkFormal(zipReader *zip.ReadCloser) (string, string, error) {
+func (bagit *Bagit) Close() error {
+	if bagit.closer == nil {
+		return nil
+	}
+	closer := bagit.closer
+	bagit.closer = nil
+	return closer.Close()
+}
+
+// SetCheckPolicy defines which payload manifests are verified.
+// the checksum list is only used with CheckList policy
+func (bagit *Bagit) SetCheckPolicy(policy CheckPolicy, checksums []string) error {
+	switch policy {
+	case CheckStrongest, CheckAll:
+	case CheckList:
+		if len(checksums) == 0 {
+			return errors.New("no checksums for check policy list")
+		}
+		for _, cs := range checksums {
+			if _, ok := checksumHierarchy[strings.ToLower(cs)]; !ok {
+				return errors.New(fmt.Sprintf("unknown checksum %s", cs))
+			}
+		}
+	default:
+		return errors.New(fmt.Sprintf("unknown check policy %s", policy))
+	}
+	bagit.checkPolicy = policy
+	bagit.checkList = []string{}
+	for _, cs := range checksums {
+		bagit.checkList = append(bagit.checkList, strings.ToLower(cs))
+	}
+	return nil
+}
+
+// SetWorkers sets the number of entries, which are verified in parallel
+func (bagit *Bagit) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	bagit.workers = workers
+}
+
+// SetRestoreMetadata restores times, permissions, owner and extended attributes from bagarc/metainfo.json on extract
+func (bagit *Bagit) SetRestoreMetadata(metadata bool) {
+	bagit.metadata = metadata
+}
+
+// SetRestoreStructure recreates symbolic links and empty directories from bagarc/metainfo.json on extract
+func (bagit *Bagit) SetRestoreStructure(structure bool) {
+	bagit.structure = structure
+}
+
+// ParseCheckPolicy reads "strongest", "all" or a comma separated list of checksums
+func ParseCheckPolicy(str string) (CheckPolicy, []string) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "", string(CheckStrongest):
+		return CheckStrongest, nil
+	case string(CheckAll):
+		return CheckAll, nil
+	}
+	checksums := []string{}
+	for _, cs := range strings.Split(str, ",") {
+		if cs = strings.TrimSpace(cs); cs != "" {
+			checksums = append(checksums, cs)
+		}
+	}
+	return CheckList, checksums
+}
+
+var manifestRegexp = regexp.MustCompile(`^(tag)?manifest-(md5|sha1|sha256|sha512|sha3-256|sha3-512)\.txt$`)
+var versionRegexp = regexp.MustCompile(`^BagIt-Version: ([0-9]+\.[0-9]+)$`)
+var encodingRegexp = regexp.MustCompile(`^Tag-File-Character-Encoding: (.+)$`)
+var oxumRegexp = regexp.MustCompile(`^Payload-Oxum\s*:\s*(.*)$`)
+var oxumValueRegexp = regexp.MustCompile(`^([0-9]+)\.([0-9]+)$`)
+var manifestLineRegexp = regexp.MustCompile(`^([A-Fa-f0-9]+)\s+(.+)$`)
+
+// find the checksum with highest rating to test
+var checksumHierarchy = map[string]int{
+	"md5":      1,
+	"sha1":     2,
+	"sha3-256": 3,
+	"sha256":   4,
+	"sha3-512": 5,
+	"sha512":   6,
+}
+
+// result of the formal check
+type bagFormal struct {
+	version      string
+	encodingName string
+	encoding     encoding.Encoding     // decoding of tag files
+	checksums    []string              // payload checksums to verify
+	manifests    []string              // checksums of all payload manifests
+	tagChecksums []string              // checksums of all tagmanifest files
+	entries      []*bagEntry           // all files of the bag
+	normalized   map[string]string     // all files of the bag with NFC form as key
+	fetch        map[string]*fetchItem // items of fetch.txt
+}
+
+func (bagit *Bagit) checkFormal(report *Report) (*bagFormal, error) {
 	bagit.logger.Infof("pass #1: checkManifest format, encodings, checksums")
 	var version string
 	var encodingName string
 	var checksums = []string{}
-	var baginfoOxumOctetCount int64
-	var baginfoOxumStreamCount int64
+	var tagChecksums = []string{}
+	var baginfoOxumOctetCount int64 = -1
+	var baginfoOxumStreamCount int64 = -1
 	var oxumOctetCount int64
 	var oxumStreamCount int64
+	var bagitTxtFound bool
+	var bagInfoFound bool
+
+	entries, err := listEntries(bagit.fsys)
+	if err != nil {
+		return nil, err
+	}
 
 	// first get all manifestRegexp files
-	manifestRegexp := regexp.MustCompile(`manifest-(md5|sha1|sha256|sha512|sha3-256|sha3-512).txt`)
-	versionRegexp := regexp.MustCompile(`BagIt-Version: ([0-9]+\.[0-9]+)`)
-	encodingRegexp := regexp.MustCompile(`Tag-File-Character-Encoding: (.+)`)
-	oxumRegexp := regexp.MustCompile(`Payload-Oxum\s*:\s*([0-9]+)\.([0-9]+)`)
-	for _, f := range zipReader.File {
-		slashPath := filepath.ToSlash(f.Name)
+	for _, f := range entries {
+		slashPath := f.name
 		found := manifestRegexp.FindStringSubmatch(slashPath)
 		if found != nil {
-			checksums = append(checksums, found[1])
-			rc, err := f.Open()
-			if err != nil {
-				return "", "", emperror.Wrapf(err, "cannot read %s", slashPath)
-			}
-			defer rc.Close()
-			h, err := os.Create(fmt.Sprintf("%s/%s", bagit.tmpdir, slashPath))
-			if err != nil {
-				return "", "", emperror.Wrapf(err, "cannot create file %s/%s", bagit.tmpdir, slashPath)
-			}
-			defer h.Close()
-			if _, err := io.Copy(h, rc); err != nil {
-				return "", "", emperror.Wrapf(err, "cannot write manifest file %s/%s", bagit.tmpdir, slashPath)
+			if found[1] == "" {
+				checksums = append(checksums, found[2])
+			} else {
+				tagChecksums = append(tagChecksums, found[2])
 			}
 		}
 
-		if slashPath == "bagarc/renames.csv" {
-			rc, err := f.Open()
-			if err != nil {
-				return "", "", emperror.Wrapf(err, "cannot read %s", "renames.csv")
-			}
-			defer rc.Close()
-			h, err := os.Create(filepath.Join(bagit.tmpdir, "renames.csv"))
-			if err != nil {
-				return "", "", emperror.Wrapf(err, "cannot create file %s/%s", bagit.tmpdir, "renames.csv")
-			}
-			defer h.Close()
-			if _, err := io.Copy(h, rc); err != nil {
-				return "", "", emperror.Wrapf(err, "cannot write manifest file %s/%s", bagit.tmpdir, "renames.csv")
-			}
-		}
 		if strings.HasPrefix(slashPath, "data/") {
 			oxumStreamCount++
-			oxumOctetCount += int64(f.UncompressedSize64)
+			oxumOctetCount += f.size
 		}
 		if slashPath == "bag-info.txt" {
-			rc, err := f.Open()
+			bagInfoFound = true
+		}
+
+		if slashPath == "bagit.txt" {
+			bagitTxtFound = true
+			rc, err := bagit.fsys.Open(slashPath)
 			if err != nil {
-				return "", "", emperror.Wrapf(err, "cannot read bag-info.txt")
+				return nil, emperror.Wrapf(err, "cannot read bagit.txt")
 			}
 			defer rc.Close()
 			scanner := bufio.NewScanner(rc)
+			lineNo := 0
 			for scanner.Scan() {
-				line := scanner.Text()
-				if found := oxumRegexp.FindStringSubmatch(line); found != nil {
-					baginfoOxumOctetCount, err = strconv.ParseInt(found[1], 10, 64)
-					if err != nil {
-						bagit.logger.Errorf("invalid octet count in bag-info.txt: %v", line)
-						break
+				lineNo++
+				line := strings.TrimRight(scanner.Text(), "\r")
+				switch lineNo {
+				case 1:
+					if strings.HasPrefix(line, "\ufeff") {
+						report.Errorf(FindingBagitTxt, slashPath, "bagit.txt must not start with a byte order mark")
+						line = strings.TrimPrefix(line, "\ufeff")
 					}
-					baginfoOxumStreamCount, err = strconv.ParseInt(found[2], 10, 64)
-					if err != nil {
-						bagit.logger.Errorf("invalid stream count in bag-info.txt: %v", line)
-						baginfoOxumOctetCount = 0
-						break
+					found := versionRegexp.FindStringSubmatch(line)
+					if found == nil {
+						report.Errorf(FindingBagitTxt, slashPath, "invalid version line %v: %s", lineNo, line)
+						continue
+					}
+					version = found[1]
+				case 2:
+					found := encodingRegexp.FindStringSubmatch(line)
+					if found == nil {
+						report.Errorf(FindingBagitTxt, slashPath, "invalid encoding line %v: %s", lineNo, line)
+						continue
+					}
+					encodingName = strings.TrimSpace(found[1])
+				default:
+					if strings.TrimSpace(line) != "" {
+						report.Errorf(FindingBagitTxt, slashPath, "invalid line %v: %s", lineNo, line)
 					}
-					break
 				}
 			}
-		} // bag-info.txt
-
-		if slashPath == "bagit.txt" {
-			rc, err := f.Open()
-			defer rc.Close()
-			if err != nil {
-				log.Fatal(err)
-			}
-			br := bufio.NewReader(rc)
-			line1, err := br.ReadString('\n')
-			if err != nil {
-				return "", "", emperror.Wrapf(err, "cannot read line 1 of bagit.txt")
+			if err := scanner.Err(); err != nil {
+				report.Errorf(FindingReadError, slashPath, "cannot read bagit.txt: %v", err)
 			}
-			found = versionRegexp.FindStringSubmatch(line1)
+			bagit.logger.Infof("Bagit v%v, encodingName %v", version, encodingName)
+		} // bagit.txt
+	} // iterate through bag content
+
+	if !bagitTxtFound {
+		report.Errorf(FindingBagitTxt, "bagit.txt", "no bagit.txt file")
+	}
+	report.Version = version
+	if version != "" && CheckVersion(version) != nil {
+		report.Warningf(FindingBagitTxt, "bagit.txt", "unknown BagIt version %s", version)
+	}
+	if encodingName == "" {
+		// try to continue with default encoding
+		encodingName = "UTF-8"
+	}
+	enc, err := getEncoding(encodingName)
+	if err != nil {
+		report.Errorf(FindingBagitTxt, "bagit.txt", "%v - trying UTF-8", err)
+		enc = unicode.UTF8
+	}
+	report.Encoding = encodingName
+	formal := &bagFormal{
+		version:      version,
+		encodingName: encodingName,
+		encoding:     enc,
+		manifests:    checksums,
+		tagChecksums: tagChecksums,
+		entries:      entries,
+		normalized:   map[string]string{},
+	}
+	for _, f := range entries {
+		formal.normalized[norm.NFC.String(f.name)] = f.name
+	}
+
+	// bag-info.txt sorts before bagit.txt, so it's read after the encoding is known
+	if bagInfoFound {
+		rc, err := bagit.openTagFile(report, formal, "bag-info.txt")
+		if err != nil {
+			return nil, emperror.Wrapf(err, "cannot read bag-info.txt")
+		}
+		defer rc.Close()
+		scanner := bufio.NewScanner(rc)
+		for scanner.Scan() {
+			line := scanner.Text()
+			found := oxumRegexp.FindStringSubmatch(line)
 			if found == nil {
-				return "", "", errors.New(fmt.Sprintf("invalid version line in bagit.txt: %s", line1))
+				continue
 			}
-			version = found[1]
-			line2, err := br.ReadString('\n')
+			value := oxumValueRegexp.FindStringSubmatch(strings.TrimSpace(found[1]))
+			if value == nil {
+				report.Errorf(FindingPayloadOxum, "bag-info.txt", "invalid Payload-Oxum: %s", line)
+				break
+			}
+			octets, err := strconv.ParseInt(value[1], 10, 64)
 			if err != nil {
-				return "", "", emperror.Wrapf(err, "cannot read line 2 of bagit.txt")
+				report.Errorf(FindingPayloadOxum, "bag-info.txt", "invalid octet count: %s", line)
+				break
 			}
-			found = encodingRegexp.FindStringSubmatch(line2)
-			if found == nil {
-				return "", "", errors.New(fmt.Sprintf("invalid encodingName line in bagit.txt: %s", line2))
+			streams, err := strconv.ParseInt(value[2], 10, 64)
+			if err != nil {
+				report.Errorf(FindingPayloadOxum, "bag-info.txt", "invalid stream count: %s", line)
+				break
 			}
-			encodingName = found[1]
-
-			bagit.logger.Infof("Bagit v%v, encodingName %v", version, encodingName)
-		} // bagit.txt
-	} // iterate through zip directory
+			baginfoOxumOctetCount, baginfoOxumStreamCount = octets, streams
+			break
+		}
+		if err := scanner.Err(); err != nil {
+			report.Errorf(FindingReadError, "bag-info.txt", "cannot read bag-info.txt: %v", err)
+		}
+	}
 
-	if version == "" || encodingName == "" {
-		return "", "", errors.New("invalid or no bagit.txt file")
+	// items of fetch.txt, which are not in the bag, are part of Payload-Oxum
+	fetch, err := bagit.readFetch(report, formal)
+	if err != nil {
+		return nil, err
 	}
-	// if this checkManifest fails, there's no need for checksum generation
-	if baginfoOxumOctetCount > 0 && baginfoOxumStreamCount > 0 {
-		if baginfoOxumOctetCount != oxumOctetCount || baginfoOxumStreamCount != oxumStreamCount {
-			return "", "", errors.New(fmt.Sprintf("invalid Payload-Oxum: %v.%v <> %v.%v",
-				oxumStreamCount, oxumStreamCount,
-				baginfoOxumOctetCount, baginfoOxumStreamCount))
+	formal.fetch = fetch
+	present := map[string]bool{}
+	for _, f := range entries {
+		present[f.name] = true
+	}
+	for name, item := range fetch {
+		if present[name] {
+			continue
+		}
+		if item.length < 0 {
+			report.Warningf(FindingPayloadOxum, "fetch.txt", "unknown length of %s - cannot verify Payload-Oxum", name)
+			baginfoOxumOctetCount = -1
+			continue
 		}
+		oxumStreamCount++
+		oxumOctetCount += item.length
 	}
 
-	// find the checksum with highest rating to test
-	var checksumHierarchy = map[string]int{
-		"md5":      1,
-		"sha1":     2,
-		"sha3-256": 3,
-		"sha256":   4,
-		"sha3-512": 5,
-		"sha512":   6,
+	if baginfoOxumOctetCount >= 0 && baginfoOxumStreamCount >= 0 {
+		if baginfoOxumOctetCount != oxumOctetCount || baginfoOxumStreamCount != oxumStreamCount {
+			report.Errorf(FindingPayloadOxum, "bag-info.txt", "invalid Payload-Oxum: %v.%v <> %v.%v",
+				oxumOctetCount, oxumStreamCount,
+				baginfoOxumOctetCount, baginfoOxumStreamCount)
+		}
 	}
-	var checksum string
-	for _, cs := range checksums {
-		cs = strings.ToLower(cs)
-		if checksum == "" {
-			checksum = cs
-		} else {
-			if checksumHierarchy[cs] > checksumHierarchy[checksum] {
+
+	var verify = []string{}
+	switch bagit.checkPolicy {
+	case CheckAll:
+		verify = appendUnique(verify, checksums...)
+	case CheckList:
+		for _, cs := range bagit.checkList {
+			found := false
+			for _, c := range checksums {
+				if c == cs {
+					found = true
+					break
+				}
+			}
+			if !found {
+				report.Errorf(FindingManifest, fmt.Sprintf("manifest-%s.txt", cs), "manifest not found")
+				continue
+			}
+			verify = appendUnique(verify, cs)
+		}
+	default:
+		var checksum string
+		for _, cs := range checksums {
+			if checksum == "" {
 				checksum = cs
+			} else {
+				if checksumHierarchy[cs] > checksumHierarchy[checksum] {
+					checksum = cs
+				}
+			}
+		}
+		if checksum != "" {
+			verify = append(verify, checksum)
+		}
+	}
+	if isVersion1(version) && len(checksums) > 0 {
+		strong := false
+		for _, cs := range checksums {
+			if checksumHierarchy[cs] > checksumHierarchy["sha1"] {
+				strong = true
 			}
 		}
+		if !strong {
+			report.Warningf(FindingManifest, "", "BagIt %s recommends sha512 instead of %v", version, checksums)
+		}
+	}
+	if len(verify) == 0 {
+		report.Errorf(FindingManifest, "", "no manifest with known checksum found")
+	} else {
+		report.Checksums = append(report.Checksums, verify...)
+		bagit.logger.Infof("using %v checksums for testing", verify)
 	}
-	if checksum == "" {
-		return "", "", errors.New("no manifest with known checksum found")
+	if len(tagChecksums) == 0 {
+		report.Warningf(FindingTagManifest, "", "no tagmanifest found")
 	}
-	bagit.logger.Infof("using %v checksum for testing", checksum)
 
-	return checksum, encodingName, nil
+	formal.checksums = verify
+	return formal, nil
 }
 
-func (bagit *Bagit) checkManifest(zipReader *zip.ReadCloser, checksum string, encodingName string, metadataSink, bag_info io.Writer) error {
-	var encDecoder *encoding.Decoder
-	switch encodingName {
-	case "UTF-8":
-		encDecoder = unicode.UTF8.NewDecoder()
-		// todo: which other decoder could be relevant????
-	default:
-		return errors.New(fmt.Sprintf("cannot handle encoding %s", encodingName))
-	}
-
-	bagit.logger.Infof("using %v checksum and manifest encoding %v for testing", checksum, encodingName)
-
-	var checksumSink hash.Hash
-	switch checksum {
-	case "md5":
-		checksumSink = md5.New()
-	case "sha1":
-		checksumSink = sha1.New()
-	case "sha256":
-		checksumSink = sha256.New()
-	case "sha512":
-		checksumSink = sha512.New()
-	case "sha3-256":
-		checksumSink = sha3.New256()
-	case "sha3-512":
-		checksumSink = sha3.New512()
-	default:
-		return errors.New(fmt.Sprintf("unknown checksum %s", checksum))
-	}
-	if err := bagit.db.Update(func(txn *badger.Txn) error {
-		for _, f := range zipReader.File {
-			rc, err := f.Open()
-			if err != nil {
-				return emperror.Wrapf(err, "cannot read %s", f.Name)
-			}
-			defer rc.Close()
-			checksumSink.Reset()
-			writers := []io.Writer{checksumSink}
-			name := filepath.ToSlash(f.Name)
-			if metadataSink != nil && (name == "bagarc/metainfo.json") {
-				writers = append(writers, metadataSink)
-			}
-			if bag_info != nil && (name == "bag-info.txt") {
-				writers = append(writers, bag_info)
+// appendUnique appends all values, which are not already in list
+func appendUnique(list []string, values ...string) []string {
+	result := append([]string{}, list...)
+	for _, val := range values {
+		found := false
+		for _, l := range result {
+			if l == val {
+				found = true
+				break
 			}
-			sink := io.MultiWriter(writers...)
-			if _, err := io.Copy(sink, rc); err != nil {
-				return emperror.Wrapf(err, "cannot calculate checksum for %s", f.Name)
-			}
-			sum := checksumSink.Sum(nil)
-			sumstr := fmt.Sprintf("%x", sum)
-			if err := txn.Set([]byte(filepath.ToSlash(f.Name)), []byte(sumstr)); err != nil {
-				return emperror.Wrapf(err, "cannot write checksum for %s", f.Name)
-			}
-			bagit.logger.Infof("%s[%s] %s", sumstr, checksum, f.Name)
 		}
-		return nil
-	}); err != nil {
-		return emperror.Wrapf(err, "cannot write checksum for into database")
+		if !found {
+			result = append(result, val)
+		}
 	}
+	return result
+}
 
-	// read manifest file and checkManifest sums
-	manifestLineRegexp := regexp.MustCompile(`([A-Fa-f0-9]+)\s+(.+)`)
-	manifestName := fmt.Sprintf("%s/manifest-%s.txt", bagit.tmpdir, checksum)
-	of, err := os.Open(manifestName)
+// verifyManifest compares the entries of a (tag)manifest file in the bag
+// with the checksums in the index. it returns all files listed in the manifest
+// and whether their checksum was correct
+func (bagit *Bagit) verifyManifest(report *Report, formal *bagFormal, checksum string, payload bool) (map[string]bool, error) {
+	var fType = FindingManifest
+	var manifest = fmt.Sprintf("manifest-%s.txt", checksum)
+	if !payload {
+		fType = FindingTagManifest
+		manifest = "tag" + manifest
+	}
+	listed := map[string]bool{}
+	forms := map[string]string{} // NFC form -> path in manifest
+	of, err := bagit.openTagFile(report, formal, manifest)
 	if err != nil {
-		return emperror.Wrapf(err, "cannot open %s", manifestName)
+		return nil, emperror.Wrapf(err, "cannot open %s", manifest)
 	}
 	defer of.Close()
-	// use the right decoder
-	of2 := transform.NewReader(of, encDecoder)
-	scanner := bufio.NewScanner(of2)
+	scanner := bufio.NewScanner(of)
+	var ok = true
 	for scanner.Scan() {
 		line := scanner.Text()
 		line = strings.TrimSpace(line)
@@ -278,113 +474,403 @@ func (bagit *Bagit) checkManifest(zipReader *zip.ReadCloser, checksum string, en
 		}
 		found := manifestLineRegexp.FindStringSubmatch(line)
 		if found == nil {
-			return errors.New(fmt.Sprintf("invalid line in %s: %s", manifestName, line))
+			report.Errorf(fType, manifest, "invalid line: %s", line)
+			ok = false
+			continue
 		}
-		var mfilename = found[2]
+		var mfilename = decodePath(formal.version, found[2])
 		var mhash = strings.ToLower(found[1])
-		var fsum string
-		if err := bagit.db.View(func(txn *badger.Txn) error {
-			item, err := txn.Get([]byte(mfilename))
-			if err != nil {
-				return emperror.Wrapf(err, "no entry for %s", mfilename)
+		listed[mfilename] = false
+		nfc := norm.NFC.String(mfilename)
+		if other, ok := forms[nfc]; ok && other != mfilename {
+			report.Warningf(fType, manifest, "%s and %s differ only by unicode normalization", other, mfilename)
+		}
+		forms[nfc] = mfilename
+		if payload && !strings.HasPrefix(mfilename, "data/") {
+			report.Errorf(FindingManifest, manifest, "%s not in payload folder", mfilename)
+			ok = false
+			continue
+		}
+		fsum, found2, err := bagit.index.Get(checksum, mfilename)
+		if err != nil {
+			return nil, emperror.Wrapf(err, "cannot get checksum of %s", mfilename)
+		}
+		if !found2 {
+			if _, ok := formal.fetch[mfilename]; ok && payload {
+				report.Warningf(FindingFetch, mfilename, "listed in fetch.txt but not fetched")
+				continue
 			}
-			if err := item.Value(func(val []byte) error {
-				fsum = string(val)
-				return nil
-			}); err != nil {
-				return emperror.Wrapf(err, "cannot get value of %s", mfilename)
+			report.Errorf(FindingMissingFile, mfilename, "listed in %s but not in archive", manifest)
+			if other, ok := formal.normalized[nfc]; ok {
+				report.Warningf(FindingMissingFile, mfilename, "%s in archive differs only by unicode normalization", other)
 			}
-			return nil
-		}); err != nil {
-			return emperror.Wrapf(err, "%s not in archive", mfilename)
+			ok = false
+			continue
 		}
 		if fsum != mhash {
-			return errors.New(fmt.Sprintf("invalid checksum %s for file %s", fsum, mfilename))
+			if payload {
+				report.Errorf(FindingChecksumMismatch, mfilename, "invalid checksum %s <> %s in %s", fsum, mhash, manifest)
+			} else {
+				report.Errorf(FindingTagManifest, mfilename, "invalid checksum %s <> %s in %s", fsum, mhash, manifest)
+			}
+			ok = false
+			continue
 		}
+		listed[mfilename] = true
 		bagit.logger.Infof("%s: ok", mfilename)
 	}
-	bagit.logger.Infof("manifest-%s.txt verified ok", checksum)
+	if err := scanner.Err(); err != nil {
+		report.Errorf(FindingReadError, manifest, "cannot read: %v", err)
+		ok = false
+	}
+	if ok {
+		bagit.logger.Infof("%s verified ok", manifest)
+	}
+	return listed, nil
+}
+
+// verifyManifests checks the payload manifests against the checksums in the
+// database and reports payload files not listed in the manifests.
+// BagIt 1.0 needs every payload file in every manifest, older versions in at least one
+func (bagit *Bagit) verifyManifests(report *Report, formal *bagFormal) error {
+	listings := map[string]map[string]bool{}
+	for _, checksum := range formal.checksums {
+		listed, err := bagit.verifyManifest(report, formal, checksum, true)
+		if err != nil {
+			return emperror.Wrapf(err, "cannot verify manifest-%s.txt", checksum)
+		}
+		listings[checksum] = listed
+	}
+	// other manifests are only read for completeness
+	for _, checksum := range formal.manifests {
+		if _, ok := listings[checksum]; ok {
+			continue
+		}
+		sums, err := bagit.readManifest(report, formal, checksum)
+		if err != nil {
+			return emperror.Wrapf(err, "cannot read manifest-%s.txt", checksum)
+		}
+		listed := map[string]bool{}
+		for name := range sums {
+			listed[name] = true
+		}
+		listings[checksum] = listed
+	}
+	verified := map[string]bool{}
+	for _, checksum := range formal.checksums {
+		verified[checksum] = true
+	}
+	for _, f := range formal.entries {
+		name := f.name
+		if !strings.HasPrefix(name, "data/") {
+			continue
+		}
+		missing := []string{}
+		for _, checksum := range formal.manifests {
+			if _, ok := listings[checksum][name]; !ok {
+				missing = append(missing, checksum)
+			}
+		}
+		switch {
+		case len(missing) == 0:
+		case isVersion1(formal.version):
+			for _, checksum := range missing {
+				report.Errorf(FindingExtraFile, name, "not listed in manifest-%s.txt", checksum)
+			}
+		case len(missing) == len(formal.manifests):
+			report.Errorf(FindingExtraFile, name, "not listed in any manifest")
+		default:
+			for _, checksum := range missing {
+				if verified[checksum] {
+					report.Warningf(FindingManifest, name, "not listed in manifest-%s.txt - not verified", checksum)
+				}
+			}
+		}
+	}
 	return nil
 }
 
-func (bagit *Bagit) Check(metadataSink, bag_info_txt io.Writer) error {
-	r, err := zip.OpenReader(bagit.bagitfile)
-	if err != nil {
-		return emperror.Wrapf(err, "cannot open zip %v", bagit.bagitfile)
+// verifyTagmanifests checks all tagmanifest files and returns the tag files,
+// which are listed in at least one tagmanifest and have no checksum errors
+func (bagit *Bagit) verifyTagmanifests(report *Report, formal *bagFormal) (map[string]bool, error) {
+	trusted := map[string]bool{}
+	for _, checksum := range formal.tagChecksums {
+		verified, err := bagit.verifyManifest(report, formal, checksum, false)
+		if err != nil {
+			return nil, emperror.Wrapf(err, "cannot verify tagmanifest-%s.txt", checksum)
+		}
+		for name, ok := range verified {
+			if t, found := trusted[name]; found {
+				trusted[name] = t && ok
+			} else {
+				trusted[name] = ok
+			}
+		}
+	}
+	for name, ok := range trusted {
+		if !ok {
+			delete(trusted, name)
+		}
 	}
-	defer r.Close()
+	return trusted, nil
+}
 
-	checksum, encodingName, err := bagit.checkFormal(r)
+// result of hashing a single bag entry
+type hashResult struct {
+	name      string
+	checksums []string
+	sums      map[string]string
+	err       error
+}
+
+// hashEntry calculates all checksums of a bag entry with its own reader
+func hashEntry(fsys fs.FS, name string, checksums []string, sink io.Writer) *hashResult {
+	result := &hashResult{name: name, checksums: checksums}
+	rc, err := fsys.Open(name)
 	if err != nil {
-		return emperror.Wrapf(err, "error running pass #1")
+		result.err = err
+		return result
+	}
+	defer rc.Close()
+	result.sums, result.err = ChecksumCopy(sink, rc, checksums)
+	return result
+}
+
+// hashEntries calculates the checksums of all bag entries, for which the filter returns
+// checksum types and stores them in the index.
+// with more than one worker, the entries are hashed in parallel, but the results are
+// processed in the order of the entries
+func (bagit *Bagit) hashEntries(entries []*bagEntry, report *Report, filter func(name string) []string, metadataSink, bag_info io.Writer) error {
+	workers := bagit.workers
+	if workers < 1 {
+		workers = 1
+	}
+	type job struct {
+		name      string
+		checksums []string
+		sink      io.Writer
+		result    chan *hashResult
 	}
+	// queue keeps the jobs in order of entries, its size limits the number of pending results
+	queue := make(chan *job, 2*workers)
+	jobs := make(chan *job)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				j.result <- hashEntry(bagit.fsys, j.name, j.checksums, j.sink)
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		for _, f := range entries {
+			name := f.name
+			checksums := filter(name)
+			if len(checksums) == 0 {
+				continue
+			}
+			writers := []io.Writer{&NullWriter{}}
+			if metadataSink != nil && (name == "bagarc/metainfo.json") {
+				writers = append(writers, metadataSink)
+			}
+			if bag_info != nil && (name == "bag-info.txt") {
+				writers = append(writers, bag_info)
+			}
+			j := &job{
+				name:      name,
+				checksums: checksums,
+				sink:      io.MultiWriter(writers...),
+				result:    make(chan *hashResult, 1),
+			}
+			queue <- j
+			jobs <- j
+		}
+	}()
 
-	if err := bagit.checkManifest(r, checksum, encodingName, metadataSink, bag_info_txt); err != nil {
-		return emperror.Wrapf(err, "error running pass #2")
+	var finalErr error
+	for j := range queue {
+		result := <-j.result
+		if finalErr != nil {
+			// drain queue to stop all workers
+			continue
+		}
+		if result.err != nil {
+			report.Errorf(FindingReadError, result.name, "cannot calculate checksum: %v", result.err)
+			continue
+		}
+		for _, checksum := range result.checksums {
+			sum := result.sums[checksum]
+			if err := bagit.index.Set(checksum, result.name, sum); err != nil {
+				finalErr = emperror.Wrapf(err, "cannot write checksum for %s", result.name)
+				break
+			}
+			bagit.logger.Infof("%s[%s] %s", sum, checksum, result.name)
+		}
 	}
+	return finalErr
+}
+
+func (bagit *Bagit) checkManifest(report *Report, formal *bagFormal, metadataSink, bag_info io.Writer) error {
+	bagit.logger.Infof("using %v checksums and manifest encoding %v for testing", formal.checksums, formal.encodingName)
 
+	// every file is read once, all checksums are calculated in parallel
+	if err := bagit.hashEntries(formal.entries, report, func(name string) []string {
+		report.Files++
+		if strings.HasPrefix(name, "data/") {
+			return formal.checksums
+		}
+		return appendUnique(formal.tagChecksums, formal.checksums...)
+	}, metadataSink, bag_info); err != nil {
+		return emperror.Wrap(err, "cannot calculate checksums")
+	}
+
+	if err := bagit.verifyManifests(report, formal); err != nil {
+		return err
+	}
+	if _, err := bagit.verifyTagmanifests(report, formal); err != nil {
+		return err
+	}
 	return nil
 }
-func (bagit *Bagit) extract(zipReader *zip.ReadCloser, targetFolder string, restoreFilenames bool, checksum string, encodingName string) error {
-	if err := os.MkdirAll(targetFolder, os.ModePerm); err != nil {
-		return emperror.Wrapf(err, "cannot create %s", targetFolder)
+
+// Validate runs all checks and collects the findings into a report.
+// the error is only set, if validation could not be done.
+func (bagit *Bagit) Validate(metadataSink, bag_info_txt io.Writer) (*Report, error) {
+	report := NewReport(bagit.bagitfile)
+
+	formal, err := bagit.checkFormal(report)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "error running pass #1")
+	}
+	if len(formal.checksums) == 0 {
+		return report, nil
+	}
+
+	if err := bagit.checkManifest(report, formal, metadataSink, bag_info_txt); err != nil {
+		return nil, emperror.Wrapf(err, "error running pass #2")
+	}
+
+	return report, nil
+}
+
+func (bagit *Bagit) Check(metadataSink, bag_info_txt io.Writer) error {
+	report, err := bagit.Validate(metadataSink, bag_info_txt)
+	if err != nil {
+		return err
 	}
+	return report.Err()
+}
 
-	// read renames into key value store if necessary
+// readRenames reads renames.csv from bag into a map zip path -> original name
+func readRenames(fsys fs.FS) (map[string]string, error) {
+	renames := map[string]string{}
+	csvFile, err := fsys.Open("bagarc/renames.csv")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return renames, nil
+		}
+		return nil, emperror.Wrapf(err, "cannot open renames.csv")
+	}
+	defer csvFile.Close()
+	csvReader := csv.NewReader(csvFile)
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, emperror.Wrapf(err, "error reading renames.csv")
+		}
+		if len(record) != 2 {
+			return nil, errors.New(fmt.Sprintf("no tupel in renames.csv: %v", record))
+		}
+		// set ZIP Path as key, original name as value
+		renames[record[1]] = record[0]
+	}
+	return renames, nil
+}
+
+func (bagit *Bagit) extract(report *Report, targetFolder string, restoreFilenames bool, formal *bagFormal) error {
+	// verify tag files before trusting any of them
+	if err := bagit.hashEntries(formal.entries, report, func(name string) []string {
+		if strings.HasPrefix(name, "data/") {
+			return nil
+		}
+		return formal.tagChecksums
+	}, nil, nil); err != nil {
+		return emperror.Wrap(err, "cannot calculate checksums of tag files")
+	}
+	trusted, err := bagit.verifyTagmanifests(report, formal)
+	if err != nil {
+		return err
+	}
+
+	// read renames if necessary
+	var renames = map[string]string{}
 	if restoreFilenames {
-		bagit.db.Update(func(txn *badger.Txn) error {
-			csvFile, err := os.Open(filepath.Join(bagit.tmpdir, "renames.csv"))
-			if err != nil && !os.IsNotExist(err) {
-				return emperror.Wrapf(err, "cannot open renames.csv")
-			}
-			if err == nil {
-				defer csvFile.Close()
-				csvReader := csv.NewReader(csvFile)
-				for {
-					record, err := csvReader.Read()
-					if err == io.EOF {
-						break
-					}
-					if err != nil {
-						return emperror.Wrapf(err, "error reading renames.csv")
-					}
-					if len(record) != 2 {
-						return errors.New(fmt.Sprintf("no tupel in renames.csv: %v", record))
-					}
-					// set ZIP Path as key, original name as value
-					if err := txn.Set([]byte(fmt.Sprintf("rename:%s", record[1])), []byte(record[0])); err != nil {
-						return emperror.Wrapf(err, "cannot store key rename:%s", record[1])
-					}
-				}
+		if renames, err = readRenames(bagit.fsys); err != nil {
+			return err
+		}
+		if len(renames) > 0 && !trusted["bagarc/renames.csv"] {
+			report.Errorf(FindingTagManifest, "bagarc/renames.csv", "not verified by tagmanifest - extracting with names of bag")
+			renames = map[string]string{}
+			restoreFilenames = false
+		}
+		for zipPath, orig := range renames {
+			// original names must not leave the data folder
+			if !fs.ValidPath(orig) {
+				report.Errorf(FindingTagManifest, "bagarc/renames.csv", "invalid filename %s for %s - extracting with name of bag", orig, zipPath)
+				delete(renames, zipPath)
 			}
-			return nil
-		})
+		}
+	}
+
+	// metadata of payload files with name in bag as key
+	var metadata = map[string]*BagitFile{}
+	var structure = []*BagitFile{}
+	withMetadata, withStructure := bagit.metadata, bagit.structure
+	if (withMetadata || withStructure) && !trusted["bagarc/metainfo.json"] {
+		report.Errorf(FindingTagManifest, "bagarc/metainfo.json", "not verified by tagmanifest - cannot restore metadata and structure")
+		withMetadata, withStructure = false, false
+	}
+	if withMetadata || withStructure {
+		records, err := readMetainfo(bagit.fsys)
+		if err != nil {
+			return err
+		}
+		for _, bf := range records {
+			if bf.Type != "" {
+				structure = append(structure, bf)
+				continue
+			}
+			if withMetadata {
+				metadata[path.Join("data", bf.ZipPath)] = bf
+			}
+		}
+	}
+
+	if err := os.MkdirAll(targetFolder, os.ModePerm); err != nil {
+		return emperror.Wrapf(err, "cannot create %s", targetFolder)
 	}
 
-	for _, f := range zipReader.File {
-		// just for th	e defer ....
+	for _, f := range formal.entries {
+		report.Files++
+		// just for the defer ....
 		err := func() error {
-			targetFilename := f.Name
-			slashName := filepath.ToSlash(f.Name)
-			if strings.HasPrefix(slashName, "data/") && restoreFilenames {
-				bagit.db.View(func(txn *badger.Txn) error {
-					key := fmt.Sprintf("rename:%s", strings.TrimPrefix(slashName, "data/"))
-					val, err := txn.Get([]byte(key))
-					if err != nil {
-						return err
-					}
-					val.Value(func(val2 []byte) error {
-						targetFilename = filepath.Join("data", string(val2))
-						return nil
-					})
-					return nil
-				})
-			}
-			sourceFile, err := f.Open()
+			slashName := f.name
+			targetFilename := filepath.FromSlash(slashName)
+			if strings.HasPrefix(slashName, "data/") {
+				if orig, ok := renames[strings.TrimPrefix(slashName, "data/")]; ok {
+					targetFilename = filepath.Join("data", orig)
+				}
+			}
+			sourceFile, err := bagit.fsys.Open(slashName)
 			if err != nil {
-				return emperror.Wrapf(err, "cannot open compressed file %s", f.Name)
+				return emperror.Wrapf(err, "cannot open file %s", slashName)
 			}
-			bagit.logger.Infof("extracting [%s] to [%s]", f.Name, targetFilename)
+			defer sourceFile.Close()
+			bagit.logger.Infof("extracting [%s] to [%s]", slashName, targetFilename)
 			targetFileFull := filepath.Join(targetFolder, targetFilename)
 			dir := filepath.Dir(targetFileFull)
 			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
@@ -397,7 +883,7 @@ func (bagit *Bagit) extract(zipReader *zip.ReadCloser, targetFolder string, rest
 
 			if _, err := io.Copy(targetFile, sourceFile); err != nil {
 				targetFile.Close()
-				return emperror.Wrapf(err, "cannot write %s -> %s", f.Name, targetFileFull)
+				return emperror.Wrapf(err, "cannot write %s -> %s", slashName, targetFileFull)
 			}
 			targetFile.Close()
 			tf, err := os.Open(targetFileFull)
@@ -405,98 +891,89 @@ func (bagit *Bagit) extract(zipReader *zip.ReadCloser, targetFolder string, rest
 				return emperror.Wrapf(err, "cannot open %s", targetFileFull)
 			}
 			defer tf.Close()
-			cSum, err := Checksum(tf, checksum)
+			cSums, err := ChecksumCopy(&NullWriter{}, tf, formal.checksums)
 			if err != nil {
 				return emperror.Wrapf(err, "cannot create checksum of %s", targetFileFull)
 			}
-			bagit.logger.Infof("%s: %s", f.Name, cSum)
-			if err := bagit.db.Update(func(txn *badger.Txn) error {
-				return txn.Set([]byte(filepath.ToSlash(f.Name)), []byte(cSum))
-			}); err != nil {
-				return emperror.Wrapf(err, "cannot set checksum for key %s", f.Name)
+			if bf, ok := metadata[slashName]; ok {
+				if err := restoreMetadata(targetFileFull, bf); err != nil {
+					report.Warningf(FindingMetadata, slashName, "%v", err)
+				}
+			}
+			for checksum, cSum := range cSums {
+				bagit.logger.Infof("%s[%s] %s", cSum, checksum, slashName)
+				if err := bagit.index.Set(checksum, slashName, cSum); err != nil {
+					return emperror.Wrapf(err, "cannot set checksum for key %s", slashName)
+				}
 			}
 			return nil
 		}()
 		if err != nil {
-			return emperror.Wrapf(err, "cannot handle %s", f.Name)
+			return emperror.Wrapf(err, "cannot handle %s", f.name)
 		}
-	} // range zipReader.File
+	} // range formal.entries
 
-	var encDecoder *encoding.Decoder
-	switch encodingName {
-	case "UTF-8":
-		encDecoder = unicode.UTF8.NewDecoder()
-		// todo: which other decoder could be relevant????
-	default:
-		return errors.New(fmt.Sprintf("cannot handle encoding %s", encodingName))
+	// links are created after the payload, so that no file is written through a link
+	if withStructure {
+		bagit.restoreStructure(report, targetFolder, restoreFilenames, structure)
 	}
 
-	bagit.logger.Infof("using %v checksum and manifest encoding %v for testing", checksum, encodingName)
+	bagit.logger.Infof("using %v checksums and manifest encoding %v for testing", formal.checksums, formal.encodingName)
 
-	// read manifest file and checkManifest sums
-	manifestLineRegexp := regexp.MustCompile(`([A-Fa-f0-9]+)\s+(.+)`)
-	manifestName := fmt.Sprintf("%s/manifest-%s.txt", bagit.tmpdir, checksum)
-	of, err := os.Open(manifestName)
-	if err != nil {
-		return emperror.Wrapf(err, "cannot open %s", manifestName)
-	}
-	defer of.Close()
-	// use the right decoder
-	of2 := transform.NewReader(of, encDecoder)
-	scanner := bufio.NewScanner(of2)
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-		// ignore empty lines
-		if line == "" {
-			continue
-		}
-		found := manifestLineRegexp.FindStringSubmatch(line)
-		if found == nil {
-			return errors.New(fmt.Sprintf("invalid line in %s: %s", manifestName, line))
+	return bagit.verifyManifests(report, formal)
+}
+
+// restoreStructure creates the recorded empty directories and symbolic links within the data folder
+func (bagit *Bagit) restoreStructure(report *Report, targetFolder string, restoreFilenames bool, records []*BagitFile) {
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+	for _, bf := range records {
+		name := bf.ZipPath
+		if restoreFilenames {
+			name = bf.Path
 		}
-		var mfilename = found[2]
-		var mhash = strings.ToLower(found[1])
-		var fsum string
-		if err := bagit.db.View(func(txn *badger.Txn) error {
-			item, err := txn.Get([]byte(mfilename))
-			if err != nil {
-				return emperror.Wrapf(err, "no entry for %s", mfilename)
+		slashName := path.Join("data", bf.ZipPath)
+		// never leave the data folder
+		targetFileFull := filepath.Join(targetFolder, "data", filepath.FromSlash(path.Clean("/"+name)))
+		switch bf.Type {
+		case FileTypeDir:
+			bagit.logger.Infof("creating folder [%s]", targetFileFull)
+			if err := os.MkdirAll(targetFileFull, os.ModePerm); err != nil {
+				report.Warningf(FindingStructure, slashName, "cannot create folder %s: %v", targetFileFull, err)
+				continue
+			}
+			if bagit.metadata {
+				if err := restoreMetadata(targetFileFull, bf); err != nil {
+					report.Warningf(FindingMetadata, slashName, "%v", err)
+				}
 			}
-			if err := item.Value(func(val []byte) error {
-				fsum = string(val)
-				return nil
-			}); err != nil {
-				return emperror.Wrapf(err, "cannot get value of %s", mfilename)
+		case FileTypeSymlink:
+			bagit.logger.Infof("creating link [%s] -> [%s]", targetFileFull, bf.Link)
+			if err := os.MkdirAll(filepath.Dir(targetFileFull), os.ModePerm); err != nil {
+				report.Warningf(FindingStructure, slashName, "cannot create folder %s: %v", filepath.Dir(targetFileFull), err)
+				continue
 			}
-			return nil
-		}); err != nil {
-			return emperror.Wrapf(err, "%s not in archive", mfilename)
-		}
-		if fsum != mhash {
-			return errors.New(fmt.Sprintf("invalid checksum %s for file %s", fsum, mfilename))
+			if err := os.Symlink(filepath.FromSlash(bf.Link), targetFileFull); err != nil {
+				report.Warningf(FindingStructure, slashName, "cannot create link to %s: %v", bf.Link, err)
+			}
+		default:
+			report.Warningf(FindingStructure, slashName, "unknown type %s", bf.Type)
 		}
-		bagit.logger.Infof("%s: ok", mfilename)
 	}
-	bagit.logger.Infof("manifest-%s.txt verified ok", checksum)
-
-	return nil
 }
 
 func (bagit *Bagit) Extract(targetFolder string, restoreFilenames bool) error {
-	r, err := zip.OpenReader(bagit.bagitfile)
-	if err != nil {
-		return emperror.Wrapf(err, "cannot open zip %v", bagit.bagitfile)
-	}
-	defer r.Close()
+	report := NewReport(bagit.bagitfile)
 
-	checksum, encodingName, err := bagit.checkFormal(r)
+	formal, err := bagit.checkFormal(report)
 	if err != nil {
 		return emperror.Wrapf(err, "error running pass #1")
 	}
+	if len(formal.checksums) == 0 {
+		return report.Err()
+	}
 
-	if err := bagit.extract(r, targetFolder, restoreFilenames, checksum, encodingName); err != nil {
+	if err := bagit.extract(report, targetFolder, restoreFilenames, formal); err != nil {
 		return emperror.Wrapf(err, "cannot extract bagit to %s", targetFolder)
 	}
-	return nil
+	return report.Err()
 }
@@ -10,32 +10,55 @@ import (
 	"github.com/dgraph-io/badger"
 	"github.com/dustin/go-humanize"
 	"github.com/goph/emperror"
+	"github.com/je4/bagarc/v2/pkg/indexer"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
 	"github.com/op/go-logging"
 	"io"
-	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
 // describes a structure for ingest process
 type BagitCreator struct {
-	logger          *logging.Logger
-	sourcedir       string            // folder to ingest
-	bagitfile       string            // resultung bagit zip file
-	checksum        []string          // list of checksums to create
-	db              *badger.DB        // file storage
-	indexer         string            // url for indexer daemon
-	indexerChecks   []string          // checks for indexer
-	tempdir         string            // folder for temporary files
-	fixFilename     bool              // true, if filenames should be corrected
-	bagInfo         map[string]string // list of entries for bag-info.txt
-	storeOnly       []string          // list of pronom id's which should be be compressed
-	oxumOctetCount  int64             // octetstream sum - octet count
-	oxumStreamCount int64             // octetstream sum - file count
-	fileMap         map[string]string
+	logger           *logging.Logger
+	sourcedir        string                 // folder to ingest
+	bagitfile        string                 // resulting bagit zip file or folder
+	checksum         []string               // list of checksums to create
+	db               *badger.DB             // file storage
+	indexer          *indexer.Client        // client of indexer daemon
+	indexerChecks    []string               // checks for indexer
+	identifier       Identifier             // embedded format identification
+	tempdir          string                 // folder for temporary files
+	filenamePolicy   FilenamePolicy         // handling of problematic filenames
+	filenameRules    *sanitize.RuleSet      // rules for FilenameRename and FilenameReject
+	collisions       sanitize.Resolution    // resolution of colliding names
+	caseSensitive    bool                   // names which differ only in case don't collide
+	normalization    sanitize.Normalization // unicode normalization of names
+	zipPaths         map[string]string      // resolved names of all files
+	bagInfo          map[string]string      // list of entries for bag-info.txt
+	storeOnly        []string               // list of pronom id's which should be be compressed
+	compressionRules []CompressionRule      // first matching rule wins
+	minGain          float64                // minimum gain of compression in percent
+	oxumOctetCount   int64                  // octetstream sum - octet count
+	oxumStreamCount  int64                  // octetstream sum - file count
+	fileMap          map[string]string
+	resume           bool         // continue interrupted creation
+	previous         *previousBag // bag to update
+	fetchRules       []FetchRule  // external files for fetch.txt
+	version          string       // BagIt version of bagit.txt
+	xattrs           bool         // record extended attributes
+	linkPolicy       LinkPolicy   // handling of symbolic links
+	emptyDirs        bool         // record empty directories
+	filter           *Filter      // include and exclude patterns
+	maxDepth         int          // maximum folder depth of files, 0 for unlimited
+	maxSize          int64        // maximum file size, 0 for unlimited
+	excluded         []excludedEntry
+	workers          int // number of files prepared in parallel
 }
 
 type rwStruct struct {
@@ -44,51 +67,198 @@ type rwStruct struct {
 }
 
 // creates a new bagit creation structure
-func NewBagitCreator(sourcedir, bagitfile string, checksum []string, bagInfo map[string]string, db *badger.DB, fixFilename bool, storeOnly []string, indexer string, indexerChecks []string, tempdir string, fileMap map[string]string, logger *logging.Logger) (*BagitCreator, error) {
+func NewBagitCreator(sourcedir, bagitfile string, checksum []string, bagInfo map[string]string, db *badger.DB, fixFilename bool, storeOnly []string, indexerUrl string, indexerChecks []string, tempdir string, fileMap map[string]string, logger *logging.Logger) (*BagitCreator, error) {
 	sourcedir = filepath.ToSlash(filepath.Clean(sourcedir))
 	bagitfile = filepath.ToSlash(filepath.Clean(bagitfile))
-	// make sure, that file does not exist...
-	_, err := os.Stat(bagitfile)
-	if !os.IsNotExist(err) {
-		return nil, errors.New(fmt.Sprintf("file %v exists", bagitfile))
-	}
 
 	bagitCreator := &BagitCreator{
-		sourcedir:     sourcedir,
-		bagitfile:     bagitfile,
-		logger:        logger,
-		checksum:      checksum,
-		db:            db,
-		fixFilename:   fixFilename,
-		indexer:       indexer,
-		indexerChecks: indexerChecks,
-		tempdir:       tempdir,
-		bagInfo:       bagInfo,
-		storeOnly:     storeOnly,
-		fileMap:       fileMap,
+		sourcedir:      sourcedir,
+		bagitfile:      bagitfile,
+		logger:         logger,
+		checksum:       checksum,
+		db:             db,
+		filenamePolicy: FilenameEncode,
+		filenameRules:  sanitize.MustPreset(sanitize.Wheeler),
+		collisions:     sanitize.ResolveNumber,
+		caseSensitive:  true,
+		indexerChecks:  indexerChecks,
+		tempdir:        tempdir,
+		bagInfo:        bagInfo,
+		storeOnly:      storeOnly,
+		fileMap:        fileMap,
+		version:        BAGITVERSION,
+		linkPolicy:     LinkFollow,
+		filter:         &Filter{},
+		workers:        1,
+	}
+	if fixFilename {
+		bagitCreator.filenamePolicy = FilenameRename
+	}
+	bagitCreator.compressionRules = bagitCreator.storeOnlyRules()
+	if indexerUrl != "" {
+		bagitCreator.indexer = indexer.NewClient(indexerUrl, indexer.DefaultConcurrency, logger)
 	}
 	return bagitCreator, nil
 }
 
+// storeOnlyRules converts the storeOnly list to a compression rule
+func (bc *BagitCreator) storeOnlyRules() []CompressionRule {
+	if len(bc.storeOnly) == 0 {
+		return []CompressionRule{}
+	}
+	return []CompressionRule{{Name: "nocompress", Pronom: bc.storeOnly, Method: CompressionStore}}
+}
+
+// SetCompressionRules defines the compression of payload files in zip bags. the first matching
+// rule wins, the storeOnly list of NewBagitCreator is checked after the rules
+func (bc *BagitCreator) SetCompressionRules(rules []CompressionRule) error {
+	compressionRules := []CompressionRule{}
+	for idx, rule := range rules {
+		if err := rule.check(idx); err != nil {
+			return err
+		}
+		compressionRules = append(compressionRules, rule)
+	}
+	bc.compressionRules = append(compressionRules, bc.storeOnlyRules()...)
+	return nil
+}
+
+// SetIndexerClient replaces the client of the indexer url from NewBagitCreator e.g. for other
+// timeouts, retries or concurrency. nil disables the indexer
+func (bc *BagitCreator) SetIndexerClient(client *indexer.Client) {
+	bc.indexer = client
+}
+
+// SetIdentifier identifies the format of all files and records it in bagarc/metainfo.json.
+// the PRONOM id of the identifier is used for compression rules, if the indexer has no siegfried result
+func (bc *BagitCreator) SetIdentifier(identifier Identifier) {
+	bc.identifier = identifier
+}
+
+// SetMinCompressionGain stores files, which are not at least percent smaller after compression.
+// the gain is estimated with the first megabyte of the file
+func (bc *BagitCreator) SetMinCompressionGain(percent float64) {
+	bc.minGain = percent
+}
+
+// SetFilenamePolicy defines, whether problematic filenames are renamed, percent-encoded or rejected
+func (bc *BagitCreator) SetFilenamePolicy(policy FilenamePolicy) {
+	bc.filenamePolicy = policy
+}
+
+// SetFilenameRules replaces the default rules (sanitize.Wheeler) for renaming
+func (bc *BagitCreator) SetFilenameRules(rules *sanitize.RuleSet) {
+	bc.filenameRules = rules
+}
+
+// SetCollisionResolution defines the handling of files, which result in the same name
+// after renaming or - if not caseSensitive - after case folding
+func (bc *BagitCreator) SetCollisionResolution(resolution sanitize.Resolution, caseSensitive bool) {
+	bc.collisions = resolution
+	bc.caseSensitive = caseSensitive
+}
+
+// SetNormalization converts all names to a unicode normalization form. original names are kept in bagarc/renames.csv
+func (bc *BagitCreator) SetNormalization(normalization sanitize.Normalization) {
+	bc.normalization = normalization
+}
+
+// SetXattrs records the extended attributes of all files in bagarc/metainfo.json
+func (bc *BagitCreator) SetXattrs(xattrs bool) {
+	bc.xattrs = xattrs
+}
+
+// SetLinkPolicy defines, whether symbolic links are followed, recorded in bagarc/metainfo.json or skipped
+func (bc *BagitCreator) SetLinkPolicy(policy LinkPolicy) {
+	bc.linkPolicy = policy
+}
+
+// SetEmptyDirs records empty directories in bagarc/metainfo.json
+func (bc *BagitCreator) SetEmptyDirs(emptyDirs bool) {
+	bc.emptyDirs = emptyDirs
+}
+
+// SetFilter selects the files of the source folder with include and exclude patterns
+func (bc *BagitCreator) SetFilter(filter *Filter) {
+	bc.filter = filter
+}
+
+// SetMaxDepth excludes files in folders deeper than maxDepth. top level files have depth 1
+func (bc *BagitCreator) SetMaxDepth(maxDepth int) {
+	bc.maxDepth = maxDepth
+}
+
+// SetMaxSize excludes files larger than maxSize bytes
+func (bc *BagitCreator) SetMaxSize(maxSize int64) {
+	bc.maxSize = maxSize
+}
+
+// SetWorkers sets the number of files, which are read, indexed and hashed in parallel
+func (bc *BagitCreator) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	bc.workers = workers
+}
+
+// SetVersion sets the BagIt version of the new bag. without checksums, the default ones of the version are used
+func (bc *BagitCreator) SetVersion(version string) error {
+	if err := CheckVersion(version); err != nil {
+		return err
+	}
+	bc.version = version
+	return nil
+}
+
+// SetResume continues an interrupted creation. files recorded in the database are not added again.
+// zip, folder and uncompressed tar bags can be resumed
+func (bc *BagitCreator) SetResume(resume bool) {
+	bc.resume = resume
+}
+
 // executes creation of bagit
 func (bc *BagitCreator) Run() (err error) {
-	// create a new zip file
-	zipFile, err := os.Create(bc.bagitfile)
+	if len(bc.checksum) == 0 {
+		bc.checksum = DefaultChecksums(bc.version)
+	}
+	entries, err := bc.scanSource()
 	if err != nil {
-		return emperror.Wrapf(err, "cannot create zip file %v", bc.bagitfile)
+		return err
 	}
-	defer zipFile.Close()
-	bc.logger.Infof("ZIP file %s created", bc.bagitfile)
-	// create writer for zip
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+	if bc.zipPaths, err = bc.resolveNames(entries, nil); err != nil {
+		return emperror.Wrap(err, "filename collision")
+	}
+	var bagWriter BagWriter
+	var hasBagitTxt bool
+	if bc.resume {
+		if bagWriter, hasBagitTxt, err = bc.resumeBag(); err != nil {
+			return emperror.Wrapf(err, "cannot resume bag %v", bc.bagitfile)
+		}
+	} else {
+		// make sure, that file does not exist...
+		if _, err := os.Stat(bc.bagitfile); !os.IsNotExist(err) {
+			return errors.New(fmt.Sprintf("file %v exists", bc.bagitfile))
+		}
+		// create a new zip file or folder
+		if bagWriter, err = NewBagWriter(bc.bagitfile); err != nil {
+			return emperror.Wrapf(err, "cannot create bag %v", bc.bagitfile)
+		}
+	}
+	defer func() {
+		if err2 := bagWriter.Close(); err2 != nil && err == nil {
+			err = emperror.Wrapf(err2, "cannot close bag %v", bc.bagitfile)
+		}
+	}()
+	bc.logger.Infof("bag %s created", bc.bagitfile)
 
-	if err := bc.writeBagitToZip(zipWriter); err != nil {
-		return emperror.Wrapf(err, "cannot write bagit")
+	if !hasBagitTxt {
+		if err := bc.writeBagitToBag(bagWriter); err != nil {
+			return emperror.Wrapf(err, "cannot write bagit")
+		}
 	}
 
-	if err := bc.fileIterator(zipWriter); err != nil {
-		return emperror.Wrapf(err, "cannot create zip")
+	if err := bc.fileIterator(entries, bagWriter); err != nil {
+		return emperror.Wrapf(err, "cannot create bag")
 	}
 
 	bc.logger.Info("creating manifest files")
@@ -96,11 +266,11 @@ func (bc *BagitCreator) Run() (err error) {
 		return emperror.Wrap(err, "error creating manifest files")
 	}
 
-	// write manifests to zip
+	// write manifests to bag
 	bc.logger.Info("adding manifest files to bagit")
-	manifestCS, err := bc.writeManifestToZip(zipWriter)
+	manifestCS, err := bc.writeManifestToBag(bagWriter)
 	if err != nil {
-		return emperror.Wrap(err, "cannot write manifests to zip")
+		return emperror.Wrap(err, "cannot write manifests to bag")
 	}
 
 	tagmanifests := map[string]map[string]string{}
@@ -116,28 +286,44 @@ func (bc *BagitCreator) Run() (err error) {
 	}
 
 	// create metadata json file
-	checksums, err := bc.writeMetainfoToZip(zipWriter)
+	checksums, err := bc.writeMetainfoToBag(bagWriter)
 	if err != nil {
-		return emperror.Wrap(err, "cannot write metainfo to zip")
+		return emperror.Wrap(err, "cannot write metainfo to bag")
 	}
 
 	for csType, cs := range checksums {
 		tagmanifests[csType]["bagarc/metainfo.json"] = cs
 	}
 
-	checksums, err = bc.writeRenamesToZip(zipWriter)
+	checksums, err = bc.writeRenamesToBag(bagWriter)
 	if err != nil {
-		return emperror.Wrap(err, "cannot write renames to zip")
+		return emperror.Wrap(err, "cannot write renames to bag")
 	}
 
 	for csType, cs := range checksums {
 		tagmanifests[csType]["bagarc/renames.csv"] = cs
 	}
 
+	checksums, err = bc.writeExcludedToBag(bagWriter)
+	if err != nil {
+		return emperror.Wrap(err, "cannot write excluded files to bag")
+	}
+	for csType, cs := range checksums {
+		tagmanifests[csType]["bagarc/excluded.csv"] = cs
+	}
+
+	checksums, err = bc.writeFetchToBag(bagWriter)
+	if err != nil {
+		return emperror.Wrap(err, "cannot write fetch.txt to bag")
+	}
+	for csType, cs := range checksums {
+		tagmanifests[csType]["fetch.txt"] = cs
+	}
+
 	//	if len(bc.bagInfo) > 0 {
-	checksums, err = bc.writeBaginfoToZip(zipWriter)
+	checksums, err = bc.writeBaginfoToBag(bagWriter)
 	if err != nil {
-		return emperror.Wrap(err, "cannot write bag-info.txt to zip")
+		return emperror.Wrap(err, "cannot write bag-info.txt to bag")
 	}
 	for csType, cs := range checksums {
 		tagmanifests[csType]["bag-info.txt"] = cs
@@ -146,18 +332,25 @@ func (bc *BagitCreator) Run() (err error) {
 
 	for csType, tags := range tagmanifests {
 		manifestfile := fmt.Sprintf("tagmanifest-%s.txt", csType)
-		f, err := zipWriter.Create(manifestfile)
+		f, err := bagWriter.Create(manifestfile, nil, zip.Deflate)
 		if err != nil {
-			return emperror.Wrapf(err, "cannot create zip file %v", manifestfile)
+			return emperror.Wrapf(err, "cannot create %v in bag", manifestfile)
 		}
 		for filename, checksum := range tags {
 			_, err = f.Write([]byte(fmt.Sprintf("%s %s\n", checksum, filename)))
 			if err != nil {
-				log.Fatal(err)
+				f.Close()
+				return emperror.Wrapf(err, "cannot write to %v", manifestfile)
 			}
 		}
+		if err := f.Close(); err != nil {
+			return emperror.Wrapf(err, "cannot close %v", manifestfile)
+		}
+	}
+	bc.logger.Infof("bag %s written", bc.bagitfile)
+	if len(bc.excluded) > 0 {
+		bc.logger.Infof("%v files of %s excluded - see bagarc/excluded.csv", len(bc.excluded), bc.sourcedir)
 	}
-	bc.logger.Infof("ZIP file %s written", bc.bagitfile)
 	return
 }
 
@@ -194,6 +387,12 @@ func (bc *BagitCreator) createManifestsAndTags() (err error) {
 	}
 	defer renamesf.Close()
 	renames := csv.NewWriter(renamesf)
+	fetchfname := filepath.Join(bc.tempdir, "fetch.txt")
+	fetch, err := os.Create(fetchfname)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot create %v", fetchfname)
+	}
+	defer fetch.Close()
 
 	metainfo.WriteString("[")
 
@@ -211,23 +410,35 @@ func (bc *BagitCreator) createManifestsAndTags() (err error) {
 				if err := json.Unmarshal(v, bf); err != nil {
 					return emperror.Wrapf(err, "cannot unmarshal json: %v", string(v))
 				}
+				if !first {
+					metainfo.WriteString(",")
+				}
+				metainfo.Write(v)
+				if bf.ZipPath != bf.Path {
+					renames.Write([]string{strings.Trim(bf.Path, "/"), strings.TrimPrefix(bf.ZipPath, "/")})
+				}
+				// links and empty directories are only recorded in metainfo.json
+				if bf.Type != "" {
+					return nil
+				}
+
+				if !isVersion1(bc.version) && strings.ContainsAny(bf.ZipPath, "\r\n") {
+					return errors.New(fmt.Sprintf("line break in %s needs percent-encoding of BagIt %s", bf.ZipPath, BAGITVERSION1))
+				}
 				for cType, cs := range bf.Checksum {
 					f, ok := manifests[cType]
 					if !ok {
 						return errors.New(fmt.Sprintf("no manifest file for checksum %s", cType))
 					}
-					if _, err := f.WriteString(fmt.Sprintf("%s %s\n", cs, "data"+bf.ZipPath)); err != nil {
+					if _, err := f.WriteString(fmt.Sprintf("%s %s\n", cs, encodePath(bc.version, "data"+bf.ZipPath))); err != nil {
 						return emperror.Wrapf(err, "cannot write checksum to %s", bf.ZipPath)
 					}
 				}
 
-				if !first {
-					metainfo.WriteString(",")
-				}
-				metainfo.Write(v)
-
-				if bf.ZipPath != bf.Path {
-					renames.Write([]string{strings.Trim(bf.Path, "/"), strings.TrimPrefix(bf.ZipPath, "/")})
+				if bf.Fetch != "" {
+					if _, err := fetch.WriteString(fmt.Sprintf("%s %v %s\n", bf.Fetch, bf.Size, encodePath(bc.version, "data"+bf.ZipPath))); err != nil {
+						return emperror.Wrapf(err, "cannot write %s to fetch.txt", bf.ZipPath)
+					}
 				}
 				//			fmt.Printf("key=%s, value=%s\n", k, v)
 				return nil
@@ -246,8 +457,8 @@ func (bc *BagitCreator) createManifestsAndTags() (err error) {
 	return nil
 }
 
-// write manifest files from temp folder to zip
-func (bc *BagitCreator) writeManifestToZip(zipWriter *zip.Writer) (map[string]map[string]string, error) {
+// write manifest files from temp folder to bag
+func (bc *BagitCreator) writeManifestToBag(bagWriter BagWriter) (map[string]map[string]string, error) {
 	csFiles := map[string]map[string]string{}
 
 	for _, cType := range bc.checksum {
@@ -255,8 +466,6 @@ func (bc *BagitCreator) writeManifestToZip(zipWriter *zip.Writer) (map[string]ma
 	}
 
 	for _, cType := range bc.checksum {
-		csFiles[cType] = map[string]string{}
-
 		manifestfile := fmt.Sprintf("manifest-%s.txt", cType)
 		bc.logger.Infof("storing %s", manifestfile)
 		mfilename := filepath.Join(bc.tempdir, manifestfile)
@@ -264,30 +473,15 @@ func (bc *BagitCreator) writeManifestToZip(zipWriter *zip.Writer) (map[string]ma
 		if err != nil {
 			return nil, emperror.Wrapf(err, "cannot stat %v", mfilename)
 		}
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return nil, emperror.Wrap(err, "cannot create zip.FileInfoHeader")
-		}
-		// we write only to the data subfolder
-		header.Name = fmt.Sprintf("manifest-%s.txt", cType)
-
-		// make sure, that compression is ok
-		header.Method = zip.Deflate
-
-		zWriter, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return nil, emperror.Wrap(err, "cannot write header to zip")
-		}
-
 		reader, err := os.Open(mfilename)
 		if err != nil {
 			return nil, emperror.Wrapf(err, "cannot open %v", mfilename)
 		}
 		defer reader.Close()
 
-		checksums, err := ChecksumCopy(zWriter, reader, bc.checksum)
+		checksums, err := bc.copyToBag(bagWriter, manifestfile, info, reader)
 		if err != nil {
-			return nil, emperror.Wrapf(err, "cannot write data to zip")
+			return nil, err
 		}
 		for mcType, cs := range checksums {
 			csFiles[mcType][manifestfile] = cs
@@ -302,40 +496,39 @@ func (bc *BagitCreator) writeManifestToZip(zipWriter *zip.Writer) (map[string]ma
 	return csFiles, nil
 }
 
-func (bc *BagitCreator) writeMetainfoToZip(zipWriter *zip.Writer) (map[string]string, error) {
-	minfofile := filepath.Join(bc.tempdir, "metainfo.json")
-	info, err := os.Stat(minfofile)
+// copyToBag writes the content of reader as deflated file to the bag and returns its checksums
+func (bc *BagitCreator) copyToBag(bagWriter BagWriter, name string, info os.FileInfo, reader io.Reader) (map[string]string, error) {
+	writer, err := bagWriter.Create(name, info, zip.Deflate)
 	if err != nil {
-		return nil, emperror.Wrapf(err, "cannot stat %v", minfofile)
+		return nil, emperror.Wrapf(err, "cannot create %s in bag", name)
 	}
-	reader, err := os.Open(minfofile)
+	checksums, err := ChecksumCopy(writer, reader, bc.checksum)
 	if err != nil {
-		return nil, emperror.Wrapf(err, "cannot open %v", minfofile)
+		writer.Close()
+		return nil, emperror.Wrapf(err, "cannot write %s to bag", name)
 	}
-	defer reader.Close()
-
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
-		// todo: error handling
+	if err := writer.Close(); err != nil {
+		return nil, emperror.Wrapf(err, "cannot close %s in bag", name)
 	}
-	header.Name = "bagarc/metainfo.json"
-
-	// make sure, that compression is ok
-	header.Method = zip.Deflate
+	return checksums, nil
+}
 
-	zWriter, err := zipWriter.CreateHeader(header)
+func (bc *BagitCreator) writeMetainfoToBag(bagWriter BagWriter) (map[string]string, error) {
+	minfofile := filepath.Join(bc.tempdir, "metainfo.json")
+	info, err := os.Stat(minfofile)
 	if err != nil {
-		return nil, emperror.Wrapf(err, "cannot create zip file header")
+		return nil, emperror.Wrapf(err, "cannot stat %v", minfofile)
 	}
-	checksums, err := ChecksumCopy(zWriter, reader, bc.checksum)
+	reader, err := os.Open(minfofile)
 	if err != nil {
-		return nil, emperror.Wrapf(err, "cannot write data to zip")
+		return nil, emperror.Wrapf(err, "cannot open %v", minfofile)
 	}
+	defer reader.Close()
 
-	return checksums, nil
+	return bc.copyToBag(bagWriter, "bagarc/metainfo.json", info, reader)
 }
 
-func (bc *BagitCreator) writeRenamesToZip(zipWriter *zip.Writer) (map[string]string, error) {
+func (bc *BagitCreator) writeRenamesToBag(bagWriter BagWriter) (map[string]string, error) {
 	renamesfile := filepath.Join(bc.tempdir, "renames.csv")
 	renames, err := os.Stat(renamesfile)
 	if err != nil {
@@ -347,36 +540,55 @@ func (bc *BagitCreator) writeRenamesToZip(zipWriter *zip.Writer) (map[string]str
 	}
 	defer reader.Close()
 
-	header, err := zip.FileInfoHeader(renames)
-	if err != nil {
-		// todo: error handling
-	}
-	header.Name = "bagarc/renames.csv"
+	return bc.copyToBag(bagWriter, "bagarc/renames.csv", renames, reader)
+}
 
-	// make sure, that compression is ok
-	header.Method = zip.Deflate
+// writeExcludedToBag writes the files, which are not part of the bag, with the reason to bagarc/excluded.csv
+func (bc *BagitCreator) writeExcludedToBag(bagWriter BagWriter) (map[string]string, error) {
+	if len(bc.excluded) == 0 {
+		return nil, nil
+	}
+	buf := bytes.NewBufferString("")
+	excluded := csv.NewWriter(buf)
+	for _, e := range bc.excluded {
+		if err := excluded.Write([]string{e.name, e.reason}); err != nil {
+			return nil, emperror.Wrapf(err, "cannot write %s to excluded.csv", e.name)
+		}
+	}
+	excluded.Flush()
+	if err := excluded.Error(); err != nil {
+		return nil, emperror.Wrap(err, "cannot write excluded.csv")
+	}
+	return bc.copyToBag(bagWriter, "bagarc/excluded.csv", nil, bytes.NewReader(buf.Bytes()))
+}
 
-	zWriter, err := zipWriter.CreateHeader(header)
+// writeFetchToBag writes fetch.txt, if there are external files
+func (bc *BagitCreator) writeFetchToBag(bagWriter BagWriter) (map[string]string, error) {
+	fetchfile := filepath.Join(bc.tempdir, "fetch.txt")
+	info, err := os.Stat(fetchfile)
 	if err != nil {
-		return nil, emperror.Wrapf(err, "cannot create zip file header")
+		return nil, emperror.Wrapf(err, "cannot stat %v", fetchfile)
+	}
+	if info.Size() == 0 {
+		return nil, nil
 	}
-	checksums, err := ChecksumCopy(zWriter, reader, bc.checksum)
+	reader, err := os.Open(fetchfile)
 	if err != nil {
-		return nil, emperror.Wrapf(err, "cannot write data to zip")
+		return nil, emperror.Wrapf(err, "cannot open %v", fetchfile)
 	}
+	defer reader.Close()
 
-	return checksums, nil
+	return bc.copyToBag(bagWriter, "fetch.txt", info, reader)
 }
 
-func (bc *BagitCreator) writeBaginfoToZip(zipWriter *zip.Writer) (map[string]string, error) {
-	writer, err := zipWriter.Create("bag-info.txt")
-	if err != nil {
-		return nil, emperror.Wrap(err, "cannot create bag-info.txt in zip")
-	}
+func (bc *BagitCreator) writeBaginfoToBag(bagWriter BagWriter) (map[string]string, error) {
 	bc.bagInfo["Bag-Software-Agent"] = fmt.Sprintf("%s", NAME)
 	bc.bagInfo["Bagging-Date"] = time.Now().Format("2006-01-02")
 	bc.bagInfo["Payload-Oxum"] = fmt.Sprintf("%v.%v", bc.oxumOctetCount, bc.oxumStreamCount)
 	bc.bagInfo["Bag-Size"] = humanize.Bytes(uint64(bc.oxumOctetCount))
+	if bc.filenamePolicy == FilenameRename {
+		bc.bagInfo["Bagarc-Filename-Rules"] = bc.filenameRules.String()
+	}
 	re := regexp.MustCompile(`\r?\n`)
 	buf := bytes.NewBufferString("")
 	for key, val := range bc.bagInfo {
@@ -385,112 +597,320 @@ func (bc *BagitCreator) writeBaginfoToZip(zipWriter *zip.Writer) (map[string]str
 	}
 	reader := bytes.NewReader(buf.Bytes())
 
-	return ChecksumCopy(writer, reader, bc.checksum)
+	return bc.copyToBag(bagWriter, "bag-info.txt", nil, reader)
 }
 
-func (bc *BagitCreator) writeBagitToZip(zipWriter *zip.Writer) error {
-	writer, err := zipWriter.Create("bagit.txt")
+func (bc *BagitCreator) writeBagitToBag(bagWriter BagWriter) error {
+	writer, err := bagWriter.Create("bagit.txt", nil, zip.Deflate)
 	if err != nil {
-		return emperror.Wrap(err, "cannot create bagit.txt in zip")
+		return emperror.Wrap(err, "cannot create bagit.txt in bag")
 	}
-	if _, err := io.WriteString(writer, fmt.Sprintf("BagIt-Version: %s\n", BAGITVERSION)); err != nil {
+	if _, err := io.WriteString(writer, fmt.Sprintf("BagIt-Version: %s\n", bc.version)); err != nil {
+		writer.Close()
 		return emperror.Wrapf(err, "cannot write to bagit.txt")
 	}
 	if _, err := io.WriteString(writer, "Tag-File-Character-Encoding: UTF-8\n"); err != nil {
+		writer.Close()
 		return emperror.Wrapf(err, "cannot write to bagit.txt")
 	}
-	return nil
+	return writer.Close()
 }
 
-func (bc *BagitCreator) doCompress(meta map[string]interface{}) (bool, error) {
-	// checkManifest wether compression should be avoided
-	sf, ok := meta["siegfried"]
-	if !ok {
-		return false, errors.New("no siegfried data from indexer")
+// files up to this size are read and hashed by the workers
+const prereadSize = 1024 * 1024
+
+// ingestItem is a prepared source entry, which is ready to be written to the bag
+type ingestItem struct {
+	bf          *BagitFile
+	skip        bool       // nothing to do
+	recordOnly  bool       // links, empty folders and external files are only recorded
+	previous    *BagitFile // unchanged file of the previous bag
+	compression *Compression
+	preread     bool       // content and checksums are already read
+	content     []byte     // content of small files
+	spool       *spoolFile // compressed content for zip bags
+	err         error
+}
+
+// prepareFile does everything except writing to the bag: metadata, checksums of external files,
+// indexer and compression of zip bags. parallel workers read and hash small files and
+// compress files for raw writing to a zip bag
+func (bc *BagitCreator) prepareFile(entry *sourceEntry, zipBag, parallel bool) *ingestItem {
+	bf, err := newBagitFileInfo(bc.sourcedir, entry.path, entry.info, bc.filenamePolicy, bc.filenameRules)
+	if err != nil {
+		return &ingestItem{err: emperror.Wrap(err, "error creating BagitFile")}
 	}
-	sflist, ok := sf.([]interface{})
-	if !ok {
-		return false, errors.New("siegfried not an array")
+	item := &ingestItem{bf: bf}
+	bc.logger.Infof("walk: %s", bf)
+	if bf.IsDir() {
+		if !bc.emptyDirs || !entry.empty {
+			item.skip = true
+			return item
+		}
+		bf.Type = FileTypeDir
+	}
+	if zipPath, ok := bc.zipPaths[bf.Path]; ok {
+		bf.ZipPath = zipPath
 	}
-	if len(sflist) == 0 {
-		return false, errors.New("siegfried is an empty array")
+	if bc.resume {
+		done, err := bc.isRecorded(bf.Path)
+		if err != nil {
+			item.err = err
+			return item
+		}
+		if done {
+			bc.logger.Infof("%s already in bag", bf)
+			item.skip = true
+			return item
+		}
 	}
-	sf0, ok := sflist[0].(map[string]interface{})
-	if !ok {
-		return false, errors.New("sf0 not a string map")
+	if bf.Type == FileTypeSymlink {
+		bc.logger.Infof("%s link to %s", bf, bf.Link)
+		item.recordOnly = true
+		return item
 	}
-	sf0id, ok := sf0["id"]
-	if !ok {
-		return false, errors.New("no id in siegfried")
+	if err := bf.readMetadata(bc.xattrs); err != nil {
+		item.err = err
+		return item
 	}
-	sfid, ok := sf0id.(string)
-	if !ok {
-		return false, errors.New("id in siegfried not a string")
+	if bf.Type == FileTypeDir {
+		item.recordOnly = true
+		return item
 	}
 
-	for _, nc := range bc.storeOnly {
-		if nc == sfid {
-			return false, nil
+	if fetchURL := bc.fetchURL(bf.Path); fetchURL != "" {
+		// external files are only listed in fetch.txt
+		if err := bf.CalculateChecksums(bc.checksum); err != nil {
+			item.err = emperror.Wrapf(err, "cannot calculate checksums of %s", bf)
+			return item
 		}
+		bf.Fetch = fetchURL
+		bc.logger.Infof("%s external: %s", bf, fetchURL)
+		item.recordOnly = true
+		return item
 	}
-	return true, nil
-}
 
-// called by file walker.
-func (bc *BagitCreator) visitFile(path string, f os.FileInfo, zipWriter *zip.Writer, txn *badger.Txn, err error) error {
-	bf, err := NewBagitFile(bc.sourcedir, path, bc.fixFilename)
-	if err != nil {
-		return emperror.Wrap(err, "error creating BagitFile")
-	}
-	bc.logger.Infof("walk: %s", bf)
-	if bf.IsDir() {
-		return nil
+	if bc.previous != nil {
+		if item.previous = bc.previousRecord(bf); item.previous != nil {
+			return item
+		}
 	}
 
-	compression := zip.Deflate
-	if bc.indexer != "" {
+	if bc.indexer != nil {
 		if err := bf.GetIndexer(bc.indexer, bc.indexerChecks, bc.fileMap); err != nil {
 			//bc.logger.Errorf("error querying indexer: %v", err)
-			return emperror.Wrapf(err, "cannot query indexer")
-		} else {
-			dc, error := bc.doCompress(bf.Indexer)
-			if error == nil {
-				if !dc {
-					compression = zip.Store
-				}
+			item.err = emperror.Wrapf(err, "cannot query indexer")
+			return item
+		}
+	}
+	if err := bc.identify(bf); err != nil {
+		item.err = err
+		return item
+	}
+	if zipBag {
+		if item.compression, err = bc.selectCompression(bf); err != nil {
+			item.err = err
+			return item
+		}
+		bf.Compression = item.compression
+		bc.logger.Debugf("%s compression: %s", bf, item.compression)
+		if parallel && item.compression.Method != CompressionStore && bf.Size <= maxSpoolSize {
+			if item.spool, err = bf.spool(bc.checksum, bc.tempdir, item.compression); err != nil {
+				item.err = err
 			}
-
+			return item
 		}
 	}
-	if err := bf.AddToZip(zipWriter, bc.checksum, compression); err != nil {
-		return emperror.Wrapf(err, "cannot add %s to zip", bf)
+	if parallel && bf.Size <= prereadSize {
+		if item.content, err = bf.ReadContent(bc.checksum); err != nil {
+			item.err = err
+			return item
+		}
+		item.preread = true
 	}
+	return item
+}
+
+// writeFile adds a prepared file to the bag and records it
+func (bc *BagitCreator) writeFile(item *ingestItem, bagWriter BagWriter) error {
+	if item.err != nil {
+		return item.err
+	}
+	bf := item.bf
+	switch {
+	case item.skip:
+		return nil
+	case item.recordOnly:
+		return bc.recordFile(bf)
+	case item.previous != nil:
+		if err := bc.copyPrevious(item.previous, bf, bagWriter); err != nil {
+			return emperror.Wrapf(err, "cannot copy %s from previous bag", bf)
+		}
+		bc.logger.Infof("%s unchanged", bf)
+		item.previous.setMetadata(bf)
+		return bc.recordFile(item.previous)
+	case item.spool != nil:
+		defer item.spool.remove()
+		zbw, ok := bagWriter.(*ZipBagWriter)
+		if !ok {
+			return errors.New(fmt.Sprintf("cannot write spooled %s to %T", bf, bagWriter))
+		}
+		if err := zbw.writeSpool(path.Join("data", bf.ZipPath), bf.info, item.spool); err != nil {
+			return emperror.Wrapf(err, "cannot add %s to bag", bf)
+		}
+	case item.preread:
+		if err := bf.AddContentToBag(bagWriter, item.content, item.compression); err != nil {
+			return emperror.Wrapf(err, "cannot add %s to bag", bf)
+		}
+	default:
+		if err := bf.AddToBag(bagWriter, bc.checksum, item.compression); err != nil {
+			return emperror.Wrapf(err, "cannot add %s to bag", bf)
+		}
+	}
+	return bc.recordFile(bf)
+}
 
+// visitFile adds a source entry to the bag
+func (bc *BagitCreator) visitFile(entry *sourceEntry, bagWriter BagWriter) error {
+	_, zipBag := bagWriter.(*ZipBagWriter)
+	return bc.writeFile(bc.prepareFile(entry, zipBag, false), bagWriter)
+}
+
+// recordFile stores a file, which has been added to the bag
+func (bc *BagitCreator) recordFile(bf *BagitFile) error {
 	// add file to key value store
 	jsonstr, err := json.Marshal(bf)
 	if err != nil {
 		return emperror.Wrap(err, "cannot marshal BagitFile")
 	}
-	txn.Set([]byte(bf.Path), jsonstr)
+	// commit every file, so that an interrupted run can be resumed
+	if err := bc.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(bf.Path), jsonstr)
+	}); err != nil {
+		return emperror.Wrapf(err, "cannot store %s in database", bf)
+	}
 
 	// calculate 0xum
+	if bf.Type != "" {
+		return nil
+	}
 	bc.oxumOctetCount += bf.Size
 	bc.oxumStreamCount++
 
 	return nil
 }
 
-// iterates through all files of source directory
-func (bc *BagitCreator) fileIterator(zipWriter *zip.Writer) (err error) {
-	// Start a writable transaction.
-	txn := bc.db.NewTransaction(true)
-	defer txn.Discard()
+// resolveNames finds files, which result in the same name within the bag, before anything is written.
+// it returns the names of all files with the source path as key. with a plan, rejected names and
+// collisions are added to the plan instead of returning an error
+func (bc *BagitCreator) resolveNames(entries []*sourceEntry, plan *Plan) (map[string]string, error) {
+	registry := sanitize.NewRegistry(bc.collisions, !bc.caseSensitive)
+	zipPaths := map[string]string{}
+	for _, entry := range entries {
+		if entry.info.IsDir() && (!bc.emptyDirs || !entry.empty) {
+			continue
+		}
+		bf, err := newBagitFileInfo(bc.sourcedir, entry.path, entry.info, bc.filenamePolicy, bc.filenameRules)
+		if err != nil {
+			if plan != nil {
+				plan.Rejected = append(plan.Rejected, &PlanEntry{Path: strings.TrimPrefix(filepath.ToSlash(entry.path), bc.sourcedir), Message: err.Error()})
+				continue
+			}
+			return nil, emperror.Wrap(err, "error creating BagitFile")
+		}
+		// the same name may come in different normalization forms from different systems
+		normalized := bc.normalization.Apply(bf.ZipPath)
+		zipPath, err := registry.Register(bf.Path, normalized)
+		if err != nil {
+			if plan != nil {
+				plan.Collisions = append(plan.Collisions, &PlanEntry{Path: bf.Path, ZipPath: normalized, Message: err.Error()})
+				continue
+			}
+			return nil, err
+		}
+		if zipPath != normalized {
+			bc.logger.Warningf("collision: %s stored as %s", bf.Path, zipPath)
+			if plan != nil {
+				plan.Collisions = append(plan.Collisions, &PlanEntry{Path: bf.Path, ZipPath: zipPath, Message: fmt.Sprintf("%s already used", normalized)})
+			}
+		}
+		zipPaths[bf.Path] = zipPath
+	}
+	return zipPaths, nil
+}
+
+// iterates through all entries of source directory. with more than one worker, files are
+// prepared in parallel and written in the order of the walk. files of zip bags are compressed
+// by the workers
+func (bc *BagitCreator) fileIterator(entries []*sourceEntry, bagWriter BagWriter) error {
+	if bc.workers <= 1 {
+		for _, entry := range entries {
+			if err := bc.visitFile(entry, bagWriter); err != nil {
+				return emperror.Wrapf(err, "cannot add %s", entry.path)
+			}
+		}
+		return nil
+	}
 
-	if err := filepath.Walk(bc.sourcedir, func(path string, f os.FileInfo, err error) error {
-		return bc.visitFile(path, f, zipWriter, txn, err)
-	}); err != nil {
-		return emperror.Wrapf(err, "cannot walk filesystem")
+	type ingestJob struct {
+		entry  *sourceEntry
+		result chan *ingestItem
 	}
-	txn.Commit()
-	return
+	jobs := make(chan *ingestJob)
+	// results in walk order. the buffer limits the number of files read ahead
+	queue := make(chan chan *ingestItem, 2*bc.workers)
+	done := make(chan struct{})
+	_, zipBag := bagWriter.(*ZipBagWriter)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < bc.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job.result <- bc.prepareFile(job.entry, zipBag, true)
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		for _, entry := range entries {
+			result := make(chan *ingestItem, 1)
+			select {
+			case jobs <- &ingestJob{entry: entry, result: result}:
+			case <-done:
+				return
+			}
+			select {
+			case queue <- result:
+			case <-done:
+				// the job is dispatched but not queued, nobody else removes its spool file
+				if item := <-result; item.spool != nil {
+					item.spool.remove()
+				}
+				return
+			}
+		}
+	}()
+
+	var err error
+	for result := range queue {
+		item := <-result
+		if err = bc.writeFile(item, bagWriter); err != nil {
+			if item.bf != nil {
+				err = emperror.Wrapf(err, "cannot add %s", item.bf)
+			}
+			break
+		}
+	}
+	close(done)
+	// remove spool files, which are not written
+	for result := range queue {
+		if item := <-result; item.spool != nil {
+			item.spool.remove()
+		}
+	}
+	wg.Wait()
+	return err
 }
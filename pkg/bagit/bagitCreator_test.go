@@ -0,0 +1,282 @@
+package bagit
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// names which differ only in case are kept by default and numbered, if case-insensitive
+func TestCollisionCase(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, map[string]string{"Foo.txt": "upper", "foo.txt": "lower"})
+
+	for _, test := range []struct {
+		name    string
+		setup   func(bc *BagitCreator)
+		payload []string
+		renames map[string]string
+	}{
+		{"default", nil, []string{"Foo.txt", "foo.txt"}, map[string]string{}},
+		{"case-insensitive", func(bc *BagitCreator) {
+			bc.SetCollisionResolution(sanitize.ResolveNumber, false)
+		}, []string{"Foo.txt", "foo-1.txt"}, map[string]string{"foo-1.txt": "foo.txt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			bagfile := filepath.Join(t.TempDir(), "bag")
+			createTestBag(t, sourcedir, bagfile, test.setup)
+			validateTestBag(t, bagfile)
+
+			entries, err := os.ReadDir(filepath.Join(bagfile, "data"))
+			if err != nil {
+				t.Fatalf("cannot read payload: %v", err)
+			}
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if !reflect.DeepEqual(names, test.payload) {
+				t.Errorf("payload %v, expected %v", names, test.payload)
+			}
+			renames, err := readRenames(os.DirFS(bagfile))
+			if err != nil {
+				t.Fatalf("cannot read renames.csv: %v", err)
+			}
+			if !reflect.DeepEqual(renames, test.renames) {
+				t.Errorf("renames.csv %v, expected %v", renames, test.renames)
+			}
+		})
+	}
+}
+
+// writeGeneratedTree creates num small text files in subfolders of dir and returns
+// the number of bytes written
+func writeGeneratedTree(t testing.TB, dir string, num int) int64 {
+	t.Helper()
+	files := map[string]string{}
+	var size int64
+	for i := 0; i < num; i++ {
+		content := strings.Repeat(fmt.Sprintf("line %v of file %v\n", i%17, i), 50+i%100)
+		files[fmt.Sprintf("folder%02d/sub%v/file%05d.txt", i%20, i%3, i)] = content
+		size += int64(len(content))
+	}
+	writeTestTree(t, dir, files)
+	return size
+}
+
+// zipEntry is the part of the central directory, which does not depend on the creation time
+type zipEntry struct {
+	name             string
+	method           uint16
+	crc32            uint32
+	compressedSize   uint64
+	uncompressedSize uint64
+}
+
+func readZipEntries(t testing.TB, zipfile string) []zipEntry {
+	t.Helper()
+	zr, err := zip.OpenReader(zipfile)
+	if err != nil {
+		t.Fatalf("cannot open %s: %v", zipfile, err)
+	}
+	defer zr.Close()
+	entries := []zipEntry{}
+	for _, f := range zr.File {
+		entries = append(entries, zipEntry{
+			name:             f.Name,
+			method:           f.Method,
+			crc32:            f.CRC32,
+			compressedSize:   f.CompressedSize64,
+			uncompressedSize: f.UncompressedSize64,
+		})
+	}
+	return entries
+}
+
+// parallel workers must result in the same bag as a single worker
+func TestFileIteratorWorkers(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeGeneratedTree(t, sourcedir, 500)
+	// files larger than prereadSize are not read ahead, incompressible files are stored
+	large := bytes.Repeat([]byte("0123456789abcdef"), prereadSize/16+1000)
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	writeTestTree(t, sourcedir, map[string]string{"folder05/large.txt": string(large), "folder07/random.bin": string(random)})
+
+	for _, bagname := range []string{"bag.zip", "bag"} {
+		t.Run(bagname, func(t *testing.T) {
+			bagfiles := []string{}
+			for _, workers := range []int{1, 4} {
+				bagfile := filepath.Join(t.TempDir(), bagname)
+				createTestBag(t, sourcedir, bagfile, func(bc *BagitCreator) {
+					bc.SetWorkers(workers)
+					if err := bc.SetCompressionRules([]CompressionRule{{Ext: []string{"bin"}, Method: CompressionStore}}); err != nil {
+						t.Fatalf("cannot set compression rules: %v", err)
+					}
+				})
+				validateTestBag(t, bagfile)
+				bagfiles = append(bagfiles, bagfile)
+			}
+			for _, name := range []string{"manifest-sha512.txt", "bagarc/renames.csv"} {
+				expected, err1 := readBagFile(bagfiles[0], name)
+				result, err2 := readBagFile(bagfiles[1], name)
+				if err1 != nil || err2 != nil || !bytes.Equal(expected, result) {
+					t.Errorf("%s differs with 4 workers", name)
+				}
+			}
+			expected, result := readTestMetainfo(t, bagfiles[0]), readTestMetainfo(t, bagfiles[1])
+			if !reflect.DeepEqual(expected, result) {
+				t.Errorf("metainfo.json differs with 4 workers")
+			}
+			if BagFormat(bagname) != FormatZip {
+				return
+			}
+			expectedEntries := readZipEntries(t, bagfiles[0])
+			resultEntries := readZipEntries(t, bagfiles[1])
+			if len(expectedEntries) != len(resultEntries) {
+				t.Fatalf("%v entries with 4 workers, expected %v", len(resultEntries), len(expectedEntries))
+			}
+			for i, exp := range expectedEntries {
+				res := resultEntries[i]
+				// tag files contain access times and maps
+				if !strings.HasPrefix(exp.name, "data/") && !strings.HasPrefix(exp.name, "manifest-") {
+					exp, res = zipEntry{name: exp.name}, zipEntry{name: res.name}
+				}
+				if exp != res {
+					t.Errorf("entry #%v with 4 workers: %+v, expected %+v", i, res, exp)
+				}
+			}
+		})
+	}
+}
+
+// an error must not leave spool files of files read ahead in tempdir
+func TestFileIteratorError(t *testing.T) {
+	sourcedir := t.TempDir()
+	large := strings.Repeat("0123456789abcdef", prereadSize/16+1000)
+	files := map[string]string{}
+	for i := 1; i < 20; i++ {
+		files[fmt.Sprintf("file%02d.txt", i)] = large
+	}
+	// the workers are ahead, while the first file is compressed
+	files["file00.txt"] = strings.Repeat(large, 20)
+	writeTestTree(t, sourcedir, files)
+
+	for i := 0; i < 5; i++ {
+		tempdir := t.TempDir()
+		db := openTestDB(t, tempdir)
+		bc := newTestCreatorDB(t, sourcedir, filepath.Join(t.TempDir(), "bag.zip"), tempdir, db)
+		bc.SetWorkers(4)
+		bc.checksum = []string{"sha512"}
+		entries, err := bc.scanSource()
+		if err != nil {
+			t.Fatalf("cannot scan %s: %v", sourcedir, err)
+		}
+		if bc.zipPaths, err = bc.resolveNames(entries, nil); err != nil {
+			t.Fatalf("cannot resolve names: %v", err)
+		}
+		// the file vanishes after the scan
+		missing := filepath.Join(sourcedir, "file01.txt")
+		if err := os.Rename(missing, missing+".moved"); err != nil {
+			t.Fatalf("cannot move %s: %v", missing, err)
+		}
+		bagWriter, err := NewBagWriter(bc.bagitfile)
+		if err != nil {
+			t.Fatalf("cannot create bag: %v", err)
+		}
+		if err := bc.fileIterator(entries, bagWriter); err == nil {
+			t.Errorf("no error with missing file")
+		}
+		bagWriter.Close()
+		db.Close()
+		if err := os.Rename(missing+".moved", missing); err != nil {
+			t.Fatalf("cannot restore %s: %v", missing, err)
+		}
+		spools, err := filepath.Glob(filepath.Join(tempdir, "spool-*"))
+		if err != nil {
+			t.Fatalf("cannot list %s: %v", tempdir, err)
+		}
+		if len(spools) > 0 {
+			t.Fatalf("%v spool files left in tempdir", len(spools))
+		}
+	}
+}
+
+// readTestMetainfo reads the records of bagarc/metainfo.json without access times, which change while reading
+func readTestMetainfo(t testing.TB, bagfile string) []*BagitFile {
+	t.Helper()
+	data, err := readBagFile(bagfile, "bagarc/metainfo.json")
+	if err != nil {
+		t.Fatalf("cannot read metainfo.json of %s: %v", bagfile, err)
+	}
+	records := []*BagitFile{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatalf("cannot unmarshal metainfo.json of %s: %v", bagfile, err)
+	}
+	for _, bf := range records {
+		bf.ATime = nil
+	}
+	return records
+}
+
+// readBagFile reads a file from a zip bag or bag folder
+func readBagFile(bagfile, name string) ([]byte, error) {
+	fsys, closer, err := OpenBagFS(bagfile, "")
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+func BenchmarkFileIterator(b *testing.B) {
+	sourcedir := b.TempDir()
+	size := writeGeneratedTree(b, sourcedir, 3000)
+	parallel := runtime.NumCPU()
+	if parallel < 4 {
+		parallel = 4
+	}
+	for _, workers := range []int{1, parallel} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tempdir := b.TempDir()
+				db := openTestDB(b, tempdir)
+				bagfile := filepath.Join(tempdir, "bag.zip")
+				bc := newTestCreatorDB(b, sourcedir, bagfile, tempdir, db)
+				bc.SetWorkers(workers)
+				entries, err := bc.scanSource()
+				if err != nil {
+					b.Fatalf("cannot scan %s: %v", sourcedir, err)
+				}
+				if bc.zipPaths, err = bc.resolveNames(entries, nil); err != nil {
+					b.Fatalf("cannot resolve names: %v", err)
+				}
+				bagWriter, err := NewBagWriter(bagfile)
+				if err != nil {
+					b.Fatalf("cannot create %s: %v", bagfile, err)
+				}
+				b.StartTimer()
+				if err := bc.fileIterator(entries, bagWriter); err != nil {
+					b.Fatalf("cannot add files: %v", err)
+				}
+				b.StopTimer()
+				bagWriter.Close()
+				db.Close()
+			}
+		})
+	}
+}
@@ -1,22 +1,45 @@
 package bagit
 
 import (
-	"archive/zip"
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/goph/emperror"
-	"io/ioutil"
-	"net/http"
+	"github.com/je4/bagarc/v2/pkg/indexer"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
+	"io"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
+// FilenamePolicy defines the handling of filenames with problematic characters
+type FilenamePolicy string
+
+const (
+	FilenameRename FilenamePolicy = "rename" // apply sanitize rules and record in bagarc/renames.csv
+	FilenameEncode FilenamePolicy = "encode" // keep name, percent-encode CR, LF and % in manifests (BagIt 1.0)
+	FilenameReject FilenamePolicy = "reject" // stop with an error
+)
+
+// ParseFilenamePolicy converts a string to a FilenamePolicy
+func ParseFilenamePolicy(str string) (FilenamePolicy, error) {
+	switch policy := FilenamePolicy(strings.ToLower(strings.TrimSpace(str))); policy {
+	case FilenameRename, FilenameEncode, FilenameReject:
+		return policy, nil
+	default:
+		return "", errors.New(fmt.Sprintf("unknown filename policy %s (rename|encode|reject)", str))
+	}
+}
+
 type BagitFile struct {
 	Path     string            `json:"path"`
 	ZipPath  string            `json:"zippath"`
@@ -24,57 +47,70 @@ type BagitFile struct {
 	Size     int64             `json:"size"`
 	//Siegfried   []SFMatches       `json:"indexer,omitempty"`
 	Indexer     map[string]interface{} `json:"indexer,omitempty"`
+	Format      *FileFormat            `json:"format,omitempty"` // result of the embedded identifier
+	Fetch       string                 `json:"fetch,omitempty"`  // url of external file
+	Type        string                 `json:"type,omitempty"`   // empty for files, FileTypeDir or FileTypeSymlink
+	Link        string                 `json:"link,omitempty"`   // target of symbolic link
+	Compression *Compression           `json:"compression,omitempty"`
+	ModTime     *time.Time             `json:"mtime,omitempty"`
+	ATime       *time.Time             `json:"atime,omitempty"`
+	Mode        os.FileMode            `json:"mode,omitempty"` // permissions
+	UID         *int                   `json:"uid,omitempty"`
+	GID         *int                   `json:"gid,omitempty"`
+	Xattrs      map[string][]byte      `json:"xattrs,omitempty"` // extended attributes
 	baseDir     string                 `json:"-"`
 	info        os.FileInfo            `json:"-"`
 	resultMutex sync.Mutex             `json:"-"`
 	errors      []error                `json:"-"`
+	indexed     *indexer.Result        `json:"-"` // typed result of the indexer
 }
 
-type SFIdentifier struct {
-	Name    string `json:"name,omitempty"`
-	Details string `json:"details,omitempty"`
-}
-
-type SFMatches struct {
-	Ns      string `json:"ns,omitempty"`
-	Id      string `json:"id,omitempty"`
-	Format  string `json:"format,omitempty"`
-	Version string `json:"version,omitempty"`
-	Mime    string `json:"mime,omitempty"`
-	Basis   string `json:"basis,omitempty"`
-	Warning string `json:"warning,omitempty"`
-}
-
-type SFFiles struct {
-	Filename string      `json:"filename,omitempty"`
-	Filesize int64       `json:"filesize,omitempty"`
-	Modified string      `json:"modified,omitempty"`
-	Errors   string      `json:"errors,omitempty"`
-	Matches  []SFMatches `json:"matches,omitempty"`
-}
-
-type SF struct {
-	Siegfried   string         `json:"indexer,omitempty"`
-	Scandate    string         `json:"scandate,omitempty"`
-	Signature   string         `json:"signature,omitempty"`
-	Created     string         `json:"created,omitempty"`
-	Identifiers []SFIdentifier `json:"identfiers,omitempty"`
-	Files       []SFFiles      `json:"files,omitempty"`
-}
+// types of records in bagarc/metainfo.json, which are not part of the payload
+const (
+	FileTypeDir     = "dir"     // empty directory
+	FileTypeSymlink = "symlink" // symbolic link
+)
 
-func NewBagitFile(baseDir, path string, fixFilename bool) (*BagitFile, error) {
+// NewBagitFile creates a file with its name in the bag. rules are used for FilenameRename and FilenameReject,
+// FixFilename if rules is nil
+func NewBagitFile(baseDir, path string, policy FilenamePolicy, rules *sanitize.RuleSet) (*BagitFile, error) {
 	// first checkManifest existence etc.
 	info, err := os.Stat(path)
 	if err != nil {
 		return nil, emperror.Wrapf(err, "cannot stat %v", path)
 	}
+	return newBagitFileInfo(baseDir, path, info, policy, rules)
+}
+
+// newBagitFileInfo creates a file with known info. symbolic links become FileTypeSymlink records
+func newBagitFileInfo(baseDir, path string, info os.FileInfo, policy FilenamePolicy, rules *sanitize.RuleSet) (*BagitFile, error) {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return nil, emperror.Wrapf(err, "cannot read link %v", path)
+		}
+	}
 	path = strings.TrimPrefix(filepath.ToSlash(path), baseDir)
 	if path == "" {
 		path = "."
 	}
+	fixFilename := FixFilename
+	if rules != nil {
+		fixFilename = rules.Sanitize
+	}
 	newPath := path
-	if fixFilename {
-		newPath = FixFilename(path)
+	switch policy {
+	case FilenameRename:
+		newPath = fixFilename(path)
+	case FilenameReject:
+		if fixFilename(path) != path {
+			return nil, errors.New(fmt.Sprintf("filename %s contains characters, which are not allowed", path))
+		}
+	case FilenameEncode:
+		if !utf8.ValidString(path) {
+			return nil, errors.New(fmt.Sprintf("filename %s is not valid UTF-8", path))
+		}
 	}
 	var bf = &BagitFile{
 		Path:     path,
@@ -85,6 +121,11 @@ func NewBagitFile(baseDir, path string, fixFilename bool) (*BagitFile, error) {
 		info:     info,
 		errors:   []error{},
 	}
+	if link != "" {
+		bf.Type = FileTypeSymlink
+		bf.Link = filepath.ToSlash(link)
+		bf.Size = 0
+	}
 	return bf, nil
 }
 
@@ -98,46 +139,93 @@ func (bf *BagitFile) IsDir() bool {
 	return bf.info.IsDir()
 }
 
-func (bf *BagitFile) AddToZip(zipWriter *zip.Writer, checksum []string, compression uint16) error {
+// AddToBag copies the file to the data folder of the bag and calculates the checksums
+func (bf *BagitFile) AddToBag(bagWriter BagWriter, checksum []string, compression *Compression) error {
 	fullpath := filepath.Join(bf.baseDir, bf.Path)
-	fileToZip, err := os.Open(fullpath)
+	fileToBag, err := os.Open(fullpath)
 	if err != nil {
 		return emperror.Wrapf(err, "cannot open %v", fullpath)
 	}
-	defer fileToZip.Close()
+	defer fileToBag.Close()
+
+	writer, err := bf.createPayload(bagWriter, compression)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot create %s in bag", bf.ZipPath)
+	}
 
-	header, err := zip.FileInfoHeader(bf.info)
+	bf.Checksum, err = ChecksumCopy(writer, fileToBag, checksum)
 	if err != nil {
-		return emperror.Wrap(err, "cannot create zip.FileInfoHeader")
+		writer.Close()
+		return emperror.Wrapf(err, "cannot write file to bag")
 	}
+	if err := writer.Close(); err != nil {
+		return emperror.Wrapf(err, "cannot close %s in bag", bf.ZipPath)
+	}
+	return nil
+}
+
+// createPayload creates the file in the data folder. nil compression is deflate with the default level
+func (bf *BagitFile) createPayload(bagWriter BagWriter, compression *Compression) (io.WriteCloser, error) {
 	// we write only to the data subfolder
-	header.Name = filepath.Join("data", bf.ZipPath)
+	name := path.Join("data", bf.ZipPath)
+	if zbw, ok := bagWriter.(*ZipBagWriter); ok && compression != nil {
+		return zbw.CreateLevel(name, bf.info, compression.zipMethod(), compression.Level)
+	}
+	return bagWriter.Create(name, bf.info, compression.zipMethod())
+}
 
-	// make sure, that compression is ok
-	header.Method = compression
+// ReadContent reads the whole file into memory and calculates the checksums
+func (bf *BagitFile) ReadContent(checksum []string) ([]byte, error) {
+	fullpath := filepath.Join(bf.baseDir, bf.Path)
+	fp, err := os.Open(fullpath)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot open %v", fullpath)
+	}
+	defer fp.Close()
+	buf := bytes.NewBuffer(make([]byte, 0, bf.Size))
+	if bf.Checksum, err = ChecksumCopy(buf, fp, checksum); err != nil {
+		return nil, emperror.Wrapf(err, "cannot read %v", fullpath)
+	}
+	return buf.Bytes(), nil
+}
 
-	zWriter, err := zipWriter.CreateHeader(header)
+// AddContentToBag writes content, which has been read with ReadContent, to the data folder of the bag
+func (bf *BagitFile) AddContentToBag(bagWriter BagWriter, content []byte, compression *Compression) error {
+	writer, err := bf.createPayload(bagWriter, compression)
 	if err != nil {
-		return emperror.Wrap(err, "cannot write header to zip")
+		return emperror.Wrapf(err, "cannot create %s in bag", bf.ZipPath)
+	}
+	if _, err := writer.Write(content); err != nil {
+		writer.Close()
+		return emperror.Wrapf(err, "cannot write file to bag")
+	}
+	if err := writer.Close(); err != nil {
+		return emperror.Wrapf(err, "cannot close %s in bag", bf.ZipPath)
 	}
+	return nil
+}
 
-	bf.Checksum, err = ChecksumCopy(zWriter, fileToZip, checksum)
+// CalculateChecksums reads the file without adding it to the bag
+func (bf *BagitFile) CalculateChecksums(checksum []string) error {
+	fullpath := filepath.Join(bf.baseDir, bf.Path)
+	fp, err := os.Open(fullpath)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot open %v", fullpath)
+	}
+	defer fp.Close()
+	bf.Checksum, err = ChecksumCopy(&NullWriter{}, fp, checksum)
 	if err != nil {
-		return emperror.Wrapf(err, "cannot write file to zip")
+		return emperror.Wrapf(err, "cannot read %v", fullpath)
 	}
 	return nil
 }
 
-func (bf *BagitFile) GetIndexer(indexer string, checks []string, fileMap map[string]string) error {
-	var query struct {
-		Url           string   `json:"url"`
-		Actions       []string `json:"actions,omitempty"`
-		ForceDownload string   `json:"forcedownload,omitempty"`
-		Headersize    int64    `json:"headersize,omitempty"`
+// GetIndexer queries the indexer with the url of the file in fileMap
+func (bf *BagitFile) GetIndexer(client *indexer.Client, checks []string, fileMap map[string]string) error {
+	query := &indexer.Query{
+		Actions:       checks,
+		ForceDownload: ".*/.*",
 	}
-	query.Actions = checks
-	query.ForceDownload = ".*/.*"
-	var result map[string]interface{}
 
 	bd := bf.baseDir
 	found := false
@@ -162,24 +250,12 @@ func (bf *BagitFile) GetIndexer(indexer string, checks []string, fileMap map[str
 	if !found {
 		return fmt.Errorf("path %s not in filemap", bd)
 	}
-	jsonstr, err := json.Marshal(query)
+	result, err := client.Index(context.Background(), query)
 	if err != nil {
-		return emperror.Wrapf(err, "cannot marshal json")
+		return err
 	}
-	resp, err := http.Post(indexer, "application/json", bytes.NewBuffer(jsonstr))
-	if err != nil {
-		return emperror.Wrapf(err, "error calling call indexer")
-	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return emperror.Wrapf(err, "error reading indexer result")
-	}
-	if err := json.Unmarshal(bodyBytes, &result); err != nil {
-		return emperror.Wrapf(err, "cannot unmarshal result")
-	}
-
-	bf.Indexer = result
+	bf.indexed = result
+	bf.Indexer = result.Map()
 
 	return nil
 }
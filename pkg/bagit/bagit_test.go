@@ -0,0 +1,119 @@
+package bagit
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rewriteTagFile replaces a tag file of a bag folder. with updateManifest, the checksum in
+// tagmanifest-sha512.txt is updated
+func rewriteTagFile(t *testing.T, bagfolder, name, content string, updateManifest bool) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(bagfolder, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+		t.Fatalf("cannot write %s: %v", name, err)
+	}
+	if !updateManifest {
+		return
+	}
+	manifest := filepath.Join(bagfolder, "tagmanifest-sha512.txt")
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("cannot read tagmanifest: %v", err)
+	}
+	sum := sha512.Sum512([]byte(content))
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasSuffix(line, " "+name) {
+			lines[i] = hex.EncodeToString(sum[:]) + " " + name
+		}
+	}
+	if err := os.WriteFile(manifest, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("cannot write tagmanifest: %v", err)
+	}
+}
+
+func TestExtractTagFiles(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, map[string]string{"Foo.txt": "upper", "foo.txt": "lower"})
+
+	for _, test := range []struct {
+		name     string
+		tamper   func(t *testing.T, bagfolder string)
+		finding  string   // part of the error, empty for none
+		files    []string // extracted payload files
+		metadata bool
+	}{
+		{
+			name:  "trusted",
+			files: []string{"Foo.txt", "foo.txt"},
+		},
+		{
+			name: "renames not verified",
+			tamper: func(t *testing.T, bagfolder string) {
+				rewriteTagFile(t, bagfolder, "bagarc/renames.csv", "bar.txt,foo-1.txt\n", false)
+			},
+			finding: "bagarc/renames.csv",
+			files:   []string{"Foo.txt", "foo-1.txt"},
+		},
+		{
+			name: "renames outside of data",
+			tamper: func(t *testing.T, bagfolder string) {
+				rewriteTagFile(t, bagfolder, "bagarc/renames.csv", "../../escape.txt,foo-1.txt\n", true)
+			},
+			finding: "bagarc/renames.csv - invalid filename ../../escape.txt",
+			files:   []string{"Foo.txt", "foo-1.txt"},
+		},
+		{
+			name: "metainfo not verified",
+			tamper: func(t *testing.T, bagfolder string) {
+				rewriteTagFile(t, bagfolder, "bagarc/metainfo.json", "[]", false)
+			},
+			finding:  "bagarc/metainfo.json",
+			files:    []string{"Foo.txt", "foo.txt"},
+			metadata: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			bagfolder := filepath.Join(t.TempDir(), "bag")
+			createTestBag(t, sourcedir, bagfolder, func(bc *BagitCreator) {
+				bc.SetCollisionResolution(sanitize.ResolveNumber, false)
+			})
+			if test.tamper != nil {
+				test.tamper(t, bagfolder)
+			}
+			checker, err := NewBagit(bagfolder, t.TempDir(), nil, testLogger)
+			if err != nil {
+				t.Fatalf("cannot open %s: %v", bagfolder, err)
+			}
+			defer checker.Close()
+			checker.SetRestoreMetadata(test.metadata)
+			target := filepath.Join(t.TempDir(), "extract")
+			err = checker.Extract(target, true)
+			switch {
+			case test.finding == "" && err != nil:
+				t.Errorf("cannot extract: %v", err)
+			case test.finding != "" && (err == nil || !strings.Contains(err.Error(), test.finding)):
+				t.Errorf("error %v, expected %s", err, test.finding)
+			}
+			entries, err := os.ReadDir(filepath.Join(target, "data"))
+			if err != nil {
+				t.Fatalf("cannot read extracted payload: %v", err)
+			}
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if strings.Join(names, " ") != strings.Join(test.files, " ") {
+				t.Errorf("extracted %v, expected %v", names, test.files)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(target), "escape.txt")); err == nil {
+				t.Errorf("file written outside of target")
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package bagit
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"github.com/goph/emperror"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// compression methods of payload files in zip bags
+const (
+	CompressionStore   = "store"
+	CompressionDeflate = "deflate"
+	CompressionZstd    = "zstd"
+)
+
+// ZipZstd is the zip method of zstd (WinZip). readers need a registered decompressor
+const ZipZstd = zstd.ZipMethodWinZip
+
+func init() {
+	zip.RegisterDecompressor(ZipZstd, zstd.ZipDecompressor())
+}
+
+// CompressionRule selects the compression of payload files by PRONOM id, MIME type or extension.
+// PRONOM id and MIME type need the siegfried check of the indexer
+type CompressionRule struct {
+	Name   string
+	Pronom []string // e.g. fmt/353
+	Mime   []string // e.g. image/jpeg or image/*
+	Ext    []string // case insensitive, without dot
+	Method string   // store, deflate or zstd
+	Level  int      // deflate 1-9, zstd 1-22, 0 for the default level
+}
+
+// check validates and normalizes the rule. idx is used as name of unnamed rules
+func (r *CompressionRule) check(idx int) error {
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("#%v", idx+1)
+	}
+	r.Method = strings.ToLower(strings.TrimSpace(r.Method))
+	maxLevel := 0
+	switch r.Method {
+	case CompressionStore:
+	case CompressionDeflate:
+		maxLevel = flate.BestCompression
+	case CompressionZstd:
+		maxLevel = 22
+	default:
+		return errors.New(fmt.Sprintf("unknown compression method %s in rule %s (store|deflate|zstd)", r.Method, r.Name))
+	}
+	if r.Level < 0 || r.Level > maxLevel {
+		return errors.New(fmt.Sprintf("invalid level %v for %s in rule %s", r.Level, r.Method, r.Name))
+	}
+	// new slice, the rules of the caller are not changed
+	var exts []string
+	for _, ext := range r.Ext {
+		exts = append(exts, strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")))
+	}
+	r.Ext = exts
+	for _, mime := range r.Mime {
+		if _, err := path.Match(mime, ""); err != nil {
+			return emperror.Wrapf(err, "invalid mime type %s in rule %s", mime, r.Name)
+		}
+	}
+	return nil
+}
+
+func (r *CompressionRule) match(pronom, mime, ext string) bool {
+	for _, p := range r.Pronom {
+		if pronom != "" && p == pronom {
+			return true
+		}
+	}
+	for _, m := range r.Mime {
+		if ok, _ := path.Match(m, mime); mime != "" && ok {
+			return true
+		}
+	}
+	for _, e := range r.Ext {
+		if ext != "" && e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Compression is the compression of a payload file in a zip bag, which is recorded in bagarc/metainfo.json
+type Compression struct {
+	Method string `json:"method"`
+	Level  int    `json:"level,omitempty"` // 0 for the default level
+	Rule   string `json:"rule,omitempty"`  // name of the matching rule
+	Note   string `json:"note,omitempty"`  // reason for storing a file despite the rule
+}
+
+// zipMethod returns the method for the zip header. nil is deflate
+func (c *Compression) zipMethod() uint16 {
+	if c == nil {
+		return zip.Deflate
+	}
+	switch c.Method {
+	case CompressionStore:
+		return zip.Store
+	case CompressionZstd:
+		return ZipZstd
+	default:
+		return zip.Deflate
+	}
+}
+
+func (c *Compression) String() string {
+	str := c.Method
+	if c.Level != 0 {
+		str += fmt.Sprintf(" %v", c.Level)
+	}
+	if c.Rule != "" {
+		str += fmt.Sprintf(" (rule %s)", c.Rule)
+	}
+	if c.Note != "" {
+		str += " - " + c.Note
+	}
+	return str
+}
+
+// compressors are expensive to create, so there is a pool for every method and level
+type compressorKey struct {
+	method uint16
+	level  int
+}
+
+var compressorPools sync.Map
+
+type resetWriteCloser interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// pooledCompressor returns the compressor to its pool on close
+type pooledCompressor struct {
+	resetWriteCloser
+	pool *sync.Pool
+}
+
+func (pc *pooledCompressor) Close() error {
+	err := pc.resetWriteCloser.Close()
+	pc.pool.Put(pc.resetWriteCloser)
+	return err
+}
+
+// newCompressor returns a deflate or zstd compressor. level 0 is the default level,
+// which is the level of archive/zip for deflate
+func newCompressor(w io.Writer, method uint16, level int) (io.WriteCloser, error) {
+	p, _ := compressorPools.LoadOrStore(compressorKey{method: method, level: level}, &sync.Pool{})
+	pool := p.(*sync.Pool)
+	if c, ok := pool.Get().(resetWriteCloser); ok {
+		c.Reset(w)
+		return &pooledCompressor{resetWriteCloser: c, pool: pool}, nil
+	}
+	var c resetWriteCloser
+	switch method {
+	case zip.Deflate:
+		if level == 0 {
+			level = zipDeflateLevel
+		}
+		fw, err := flate.NewWriter(w, level)
+		if err != nil {
+			return nil, emperror.Wrap(err, "cannot create deflate writer")
+		}
+		c = fw
+	case ZipZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		enc, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return nil, emperror.Wrap(err, "cannot create zstd encoder")
+		}
+		c = enc
+	default:
+		return nil, errors.New(fmt.Sprintf("no compressor for zip method %v", method))
+	}
+	return &pooledCompressor{resetWriteCloser: c, pool: pool}, nil
+}
+
+// selectCompression returns the compression of the first matching rule or deflate with the default level.
+// the format of siegfried is preferred to the one of the identifier.
+// with a minimum gain, files which cannot be compressed enough are stored
+func (bc *BagitCreator) selectCompression(bf *BagitFile) (*Compression, error) {
+	var pronom, mime string
+	if bf.indexed != nil {
+		var err error
+		if pronom, mime, err = bf.indexed.PRONOM(); err != nil {
+			bc.logger.Debugf("%s: %v", bf, err)
+		}
+	}
+	if pronom == "" && bf.Format != nil {
+		pronom, mime = bf.Format.Id, bf.Format.Mime
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(bf.Path), "."))
+	compression := &Compression{Method: CompressionDeflate}
+	for _, rule := range bc.compressionRules {
+		if rule.match(pronom, mime, ext) {
+			compression = &Compression{Method: rule.Method, Level: rule.Level, Rule: rule.Name}
+			break
+		}
+	}
+	if bc.minGain > 0 && compression.Method != CompressionStore {
+		gain, err := bf.estimateGain(compression.zipMethod(), compression.Level)
+		if err != nil {
+			return nil, err
+		}
+		if gain < bc.minGain {
+			compression.Note = fmt.Sprintf("gain %.1f%% < %v%%", gain, bc.minGain)
+			compression.Method = CompressionStore
+			compression.Level = 0
+		}
+	}
+	return compression, nil
+}
+
+// estimateGain compresses the beginning of the file and returns the saved space in percent
+func (bf *BagitFile) estimateGain(method uint16, level int) (float64, error) {
+	fullpath := filepath.Join(bf.baseDir, bf.Path)
+	fp, err := os.Open(fullpath)
+	if err != nil {
+		return 0, emperror.Wrapf(err, "cannot open %v", fullpath)
+	}
+	defer fp.Close()
+	compressed := &countWriter{w: io.Discard}
+	c, err := newCompressor(compressed, method, level)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(c, io.LimitReader(fp, prereadSize))
+	if err2 := c.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return 0, emperror.Wrapf(err, "cannot compress %v", fullpath)
+	}
+	if n == 0 || compressed.count >= n {
+		return 0, nil
+	}
+	return 100 * (1 - float64(compressed.count)/float64(n)), nil
+}
@@ -0,0 +1,46 @@
+package bagit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetCompressionRules(t *testing.T) {
+	bc, err := NewBagitCreator("", "", nil, nil, nil, false, []string{"fmt/353"}, "", nil, "", nil, testLogger)
+	if err != nil {
+		t.Fatalf("cannot create BagitCreator: %v", err)
+	}
+	rules := []CompressionRule{
+		{Ext: []string{".TIF", " Jpg "}, Method: " Store "},
+		{Name: "text", Mime: []string{"text/*"}, Method: "zstd", Level: 19},
+	}
+	original := []CompressionRule{
+		{Ext: []string{".TIF", " Jpg "}, Method: " Store "},
+		{Name: "text", Mime: []string{"text/*"}, Method: "zstd", Level: 19},
+	}
+	if err := bc.SetCompressionRules(rules); err != nil {
+		t.Fatalf("cannot set compression rules: %v", err)
+	}
+	if !reflect.DeepEqual(rules, original) {
+		t.Errorf("rules of caller changed to %+v", rules)
+	}
+	expected := []CompressionRule{
+		{Name: "#1", Ext: []string{"tif", "jpg"}, Method: CompressionStore},
+		{Name: "text", Mime: []string{"text/*"}, Method: CompressionZstd, Level: 19},
+		{Name: "nocompress", Pronom: []string{"fmt/353"}, Method: CompressionStore},
+	}
+	if !reflect.DeepEqual(bc.compressionRules, expected) {
+		t.Errorf("rules %+v, expected %+v", bc.compressionRules, expected)
+	}
+
+	for _, rule := range []CompressionRule{
+		{Method: "lzma"},
+		{Method: CompressionDeflate, Level: 10},
+		{Method: CompressionZstd, Level: -1},
+		{Mime: []string{"image/["}, Method: CompressionStore},
+	} {
+		if err := bc.SetCompressionRules([]CompressionRule{rule}); err == nil {
+			t.Errorf("invalid rule %+v accepted", rule)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package bagit
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"github.com/goph/emperror"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// file within a bag container
+type bagEntry struct {
+	name string
+	size int64
+}
+
+// listEntries returns all files of a bag container in lexical order
+func listEntries(fsys fs.FS) ([]*bagEntry, error) {
+	entries := []*bagEntry{}
+	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return emperror.Wrapf(err, "cannot stat %s", path)
+		}
+		entries = append(entries, &bagEntry{name: path, size: info.Size()})
+		return nil
+	}); err != nil {
+		return nil, emperror.Wrap(err, "cannot list bag content")
+	}
+	return entries, nil
+}
+
+// serializations of bags
+const (
+	FormatFolder = "folder"
+	FormatZip    = "zip"
+	FormatTar    = "tar"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+)
+
+// BagFormat detects the serialization from the file extension. unknown extensions are folders
+func BagFormat(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return FormatTarZst
+	default:
+		return FormatFolder
+	}
+}
+
+// OpenBagFS opens a bag container for reading.
+// folders are read as unserialized bag, tar files by extension and everything else as zip.
+// compressed tars are decompressed to tempdir. the closer is nil for folders
+func OpenBagFS(path, tempdir string) (fs.FS, io.Closer, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, emperror.Wrapf(err, "cannot stat %s", path)
+	}
+	if stat.IsDir() {
+		return os.DirFS(path), nil, nil
+	}
+	var tarCompression string
+	switch BagFormat(path) {
+	case FormatTar:
+		tarCompression = TarPlain
+	case FormatTarGz:
+		tarCompression = TarGzip
+	case FormatTarZst:
+		tarCompression = TarZstd
+	default:
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, nil, emperror.Wrapf(err, "cannot open zip %s", path)
+		}
+		return zr, zr, nil
+	}
+	tfs, err := OpenTarFS(path, tarCompression, tempdir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tfs, tfs, nil
+}
+
+// NewBagWriter creates the bag container for target. the format is chosen by
+// the extension of target (see BagFormat)
+func NewBagWriter(target string) (BagWriter, error) {
+	format := BagFormat(target)
+	if format == FormatFolder {
+		return NewDirBagWriter(target)
+	}
+	fp, err := os.Create(target)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot create %s file %v", format, target)
+	}
+	var bagWriter BagWriter
+	switch format {
+	case FormatTar:
+		bagWriter, err = NewTarBagWriter(fp, TarPlain)
+	case FormatTarGz:
+		bagWriter, err = NewTarBagWriter(fp, TarGzip)
+	case FormatTarZst:
+		bagWriter, err = NewTarBagWriter(fp, TarZstd)
+	default:
+		bagWriter = NewZipBagWriter(fp)
+	}
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return bagWriter, nil
+}
+
+// BagWriter stores files in a bag container
+type BagWriter interface {
+	// Create adds a new file to the bag. info may be nil, compression is a hint,
+	// which is ignored by containers without compression
+	Create(name string, info fs.FileInfo, compression uint16) (io.WriteCloser, error)
+	Close() error
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ZipBagWriter writes a bag as zip file
+type ZipBagWriter struct {
+	w      *zip.Writer
+	closer io.Closer
+	level  int // compression level of the current file
+}
+
+// NewZipBagWriter creates zip writer. if dst is an io.Closer, it's closed with the writer
+func NewZipBagWriter(dst io.Writer) *ZipBagWriter {
+	zbw := &ZipBagWriter{w: zip.NewWriter(dst)}
+	if closer, ok := dst.(io.Closer); ok {
+		zbw.closer = closer
+	}
+	for _, method := range []uint16{zip.Deflate, ZipZstd} {
+		method := method
+		zbw.w.RegisterCompressor(method, func(w io.Writer) (io.WriteCloser, error) {
+			return newCompressor(w, method, zbw.level)
+		})
+	}
+	return zbw
+}
+
+// CreateLevel adds a new file, which is compressed with method (zip.Store, zip.Deflate or ZipZstd)
+// and level. level 0 is the default level
+func (zbw *ZipBagWriter) CreateLevel(name string, info fs.FileInfo, method uint16, level int) (io.WriteCloser, error) {
+	zbw.level = level
+	defer func() { zbw.level = 0 }()
+	return zbw.Create(name, info, method)
+}
+
+func (zbw *ZipBagWriter) Create(name string, info fs.FileInfo, compression uint16) (io.WriteCloser, error) {
+	var header *zip.FileHeader
+	if info != nil {
+		var err error
+		if header, err = zip.FileInfoHeader(info); err != nil {
+			return nil, emperror.Wrap(err, "cannot create zip.FileInfoHeader")
+		}
+	} else {
+		header = &zip.FileHeader{}
+	}
+	header.Name = filepath.ToSlash(name)
+	// make sure, that compression is ok
+	header.Method = compression
+
+	w, err := zbw.w.CreateHeader(header)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot write header of %s to zip", name)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (zbw *ZipBagWriter) Close() error {
+	finalError := emperror.NewMultiErrorBuilder()
+	if err := zbw.w.Close(); err != nil {
+		finalError.Add(err)
+	}
+	if zbw.closer != nil {
+		if err := zbw.closer.Close(); err != nil {
+			finalError.Add(err)
+		}
+	}
+	return finalError.ErrOrNil()
+}
+
+// DirBagWriter writes an unserialized bag into a folder
+type DirBagWriter struct {
+	folder string
+}
+
+func NewDirBagWriter(folder string) (*DirBagWriter, error) {
+	if _, err := os.Stat(folder); err == nil {
+		return nil, errors.New(fmt.Sprintf("folder %s exists", folder))
+	}
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return nil, emperror.Wrapf(err, "cannot create folder %s", folder)
+	}
+	return &DirBagWriter{folder: folder}, nil
+}
+
+type dirFile struct {
+	*os.File
+	modTime time.Time
+}
+
+func (df *dirFile) Close() error {
+	if err := df.File.Close(); err != nil {
+		return err
+	}
+	if !df.modTime.IsZero() {
+		if err := os.Chtimes(df.Name(), df.modTime, df.modTime); err != nil {
+			return emperror.Wrapf(err, "cannot set modification time of %s", df.Name())
+		}
+	}
+	return nil
+}
+
+func (dbw *DirBagWriter) Create(name string, info fs.FileInfo, compression uint16) (io.WriteCloser, error) {
+	fullpath := filepath.Join(dbw.folder, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fullpath), os.ModePerm); err != nil {
+		return nil, emperror.Wrapf(err, "cannot create folder for %s", fullpath)
+	}
+	fp, err := os.Create(fullpath)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot create %s", fullpath)
+	}
+	df := &dirFile{File: fp}
+	if info != nil {
+		df.modTime = info.ModTime()
+	}
+	return df, nil
+}
+
+func (dbw *DirBagWriter) Close() error { return nil }
@@ -0,0 +1,132 @@
+package bagit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/goph/emperror"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// size of the beginning of a tag file, which is checked against the declared encoding
+const encodingSampleSize = 64 * 1024
+
+// getEncoding resolves an IANA encoding name (case-insensitive)
+func getEncoding(encodingName string) (encoding.Encoding, error) {
+	enc, err := ianaindex.IANA.Encoding(strings.TrimSpace(encodingName))
+	if err != nil {
+		return nil, emperror.Wrapf(err, "unknown encoding %s", encodingName)
+	}
+	if enc == nil {
+		return nil, errors.New(fmt.Sprintf("unsupported encoding %s", encodingName))
+	}
+	return enc, nil
+}
+
+// validUTF8 checks text, which may end with an incomplete rune
+func validUTF8(sample []byte) bool {
+	for len(sample) > 0 {
+		r, size := utf8.DecodeRune(sample)
+		if r == utf8.RuneError && size <= 1 {
+			return len(sample) < utf8.UTFMax && !utf8.FullRune(sample)
+		}
+		sample = sample[size:]
+	}
+	return true
+}
+
+// encodingMismatch compares the beginning of a tag file with the declared encoding.
+// it returns a description of the mismatch and whether the file cannot be read correctly
+func encodingMismatch(sample []byte, enc encoding.Encoding, encodingName string) (string, bool) {
+	name, err := ianaindex.IANA.Name(enc)
+	if err != nil {
+		name = encodingName
+	}
+	name = strings.ToUpper(name)
+	isUTF8 := name == "UTF-8"
+	isUTF16 := strings.HasPrefix(name, "UTF-16")
+
+	var bom string
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xef, 0xbb, 0xbf}):
+		bom = "UTF-8"
+	case bytes.HasPrefix(sample, []byte{0xfe, 0xff}):
+		bom = "UTF-16BE"
+	case bytes.HasPrefix(sample, []byte{0xff, 0xfe}):
+		bom = "UTF-16LE"
+	}
+	if bom != "" {
+		switch {
+		case bom == "UTF-8" && !isUTF8,
+			bom != "UTF-8" && !isUTF16,
+			bom != "UTF-8" && name != "UTF-16" && name != bom:
+			return fmt.Sprintf("%s byte order mark, but declared encoding is %s", bom, encodingName), true
+		}
+		return "", false
+	}
+
+	zeros := bytes.Count(sample, []byte{0})
+	if isUTF16 {
+		if len(sample) > 0 && zeros == 0 {
+			return fmt.Sprintf("declared encoding is %s, but content is not UTF-16", encodingName), true
+		}
+		return "", false
+	}
+	if zeros > 0 {
+		return fmt.Sprintf("content contains null bytes (UTF-16?), but declared encoding is %s", encodingName), true
+	}
+	if isUTF8 {
+		if !validUTF8(sample) {
+			return "content is not valid UTF-8", true
+		}
+		return "", false
+	}
+	// single or multi byte encodings accept nearly every byte sequence, but multibyte
+	// UTF-8 sequences are a strong hint for a wrong declaration
+	if !utf8.Valid(sample) || !validUTF8(sample) {
+		return "", false
+	}
+	for _, b := range sample {
+		if b >= 0x80 {
+			return fmt.Sprintf("content looks like UTF-8, but declared encoding is %s", encodingName), false
+		}
+	}
+	return "", false
+}
+
+// tagFile reads a decoded tag file
+type tagFile struct {
+	io.Reader
+	io.Closer
+}
+
+// openTagFile opens a tag file and decodes it with the encoding declared in bagit.txt.
+// byte order marks are removed. a mismatch between declared encoding and content is reported
+func (bagit *Bagit) openTagFile(report *Report, formal *bagFormal, name string) (io.ReadCloser, error) {
+	fp, err := bagit.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(fp, encodingSampleSize)
+	sample, err := br.Peek(encodingSampleSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		fp.Close()
+		return nil, emperror.Wrapf(err, "cannot read %s", name)
+	}
+	if msg, fatal := encodingMismatch(sample, formal.encoding, formal.encodingName); msg != "" {
+		if fatal {
+			report.Errorf(FindingEncoding, name, "%s", msg)
+		} else {
+			report.Warningf(FindingEncoding, name, "%s", msg)
+		}
+	}
+	decoder := unicode.BOMOverride(formal.encoding.NewDecoder())
+	return &tagFile{Reader: transform.NewReader(br, decoder), Closer: fp}, nil
+}
@@ -0,0 +1,118 @@
+package bagit
+
+import (
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGetEncoding(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		valid bool
+	}{
+		{"UTF-8", true},
+		{"utf-8", true},
+		{" ISO-8859-1 ", true},
+		{"latin1", true},
+		{"UTF-16", true},
+		{"UTF-16LE", true},
+		// known by IANA, but not supported
+		{"UTF-7", false},
+		{"UTF-32", false},
+		{"x-unknown-charset", false},
+		{"", false},
+	} {
+		enc, err := getEncoding(test.name)
+		if test.valid && (err != nil || enc == nil) {
+			t.Errorf("%q: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%q: no error", test.name)
+		}
+	}
+}
+
+func encodeUTF16(t *testing.T, endianness unicode.Endianness, bom unicode.BOMPolicy, text string) string {
+	t.Helper()
+	data, err := unicode.UTF16(endianness, bom).NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("cannot encode %q: %v", text, err)
+	}
+	return string(data)
+}
+
+func TestOpenTagFile(t *testing.T) {
+	const text = "Source-Organization: Universität Basel\n"
+	latin1, err := charmap.ISO8859_1.NewEncoder().String(text)
+	if err != nil {
+		t.Fatalf("cannot encode %q: %v", text, err)
+	}
+	// the rune at the end of the sample is incomplete
+	long := strings.Repeat("a", encodingSampleSize-1) + "ä\n"
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		content  string
+		severity Severity // empty for no finding
+		text     string   // decoded content, empty if not checked
+	}{
+		{"utf-8", "UTF-8", text, "", text},
+		{"utf-8 bom", "UTF-8", "\xef\xbb\xbf" + text, "", text},
+		{"utf-8 incomplete sample", "UTF-8", long, "", long},
+		{"utf-8 invalid", "UTF-8", latin1, SeverityError, ""},
+		{"utf-8 with utf-16 bom", "UTF-8", encodeUTF16(t, unicode.LittleEndian, unicode.UseBOM, text), SeverityError, ""},
+		{"utf-8 with utf-16 content", "UTF-8", encodeUTF16(t, unicode.LittleEndian, unicode.IgnoreBOM, text), SeverityError, ""},
+		{"utf-16le bom", "UTF-16", encodeUTF16(t, unicode.LittleEndian, unicode.UseBOM, text), "", text},
+		{"utf-16be bom", "UTF-16", encodeUTF16(t, unicode.BigEndian, unicode.UseBOM, text), "", text},
+		{"utf-16be without bom", "UTF-16BE", encodeUTF16(t, unicode.BigEndian, unicode.IgnoreBOM, text), "", text},
+		{"utf-16le with utf-16be bom", "UTF-16LE", encodeUTF16(t, unicode.BigEndian, unicode.UseBOM, text), SeverityError, ""},
+		{"utf-16 with ascii content", "UTF-16", "Source-Organization: Basel\n", SeverityError, ""},
+		{"latin1", "ISO-8859-1", latin1, "", text},
+		{"latin1 with utf-8 content", "ISO-8859-1", text, SeverityWarning, ""},
+		{"latin1 ascii", "ISO-8859-1", "Source-Organization: Basel\n", "", "Source-Organization: Basel\n"},
+		{"latin1 with utf-8 bom", "ISO-8859-1", "\xef\xbb\xbf" + text, SeverityError, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			enc, err := getEncoding(test.encoding)
+			if err != nil {
+				t.Fatalf("cannot get encoding %s: %v", test.encoding, err)
+			}
+			bagit := &Bagit{fsys: fstest.MapFS{"bag-info.txt": &fstest.MapFile{Data: []byte(test.content)}}}
+			report := NewReport("test")
+			rc, err := bagit.openTagFile(report, &bagFormal{encodingName: test.encoding, encoding: enc}, "bag-info.txt")
+			if err != nil {
+				t.Fatalf("cannot open tag file: %v", err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("cannot read tag file: %v", err)
+			}
+			var severity Severity
+			for _, finding := range report.Findings {
+				if finding.Type != FindingEncoding || finding.File != "bag-info.txt" {
+					t.Errorf("unexpected finding %s", finding)
+				}
+				severity = finding.Severity
+			}
+			if len(report.Findings) > 1 || severity != test.severity {
+				t.Errorf("findings %v, expected severity %q", report.Findings, test.severity)
+			}
+			if test.text != "" && string(data) != test.text {
+				t.Errorf("decoded %q, expected %q", shorten(string(data)), shorten(test.text))
+			}
+		})
+	}
+}
+
+func shorten(s string) string {
+	if len(s) > 50 {
+		return s[:20] + "..." + s[len(s)-20:]
+	}
+	return s
+}
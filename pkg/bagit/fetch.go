@@ -0,0 +1,303 @@
+package bagit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/goph/emperror"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFetchTimeout limits a single download of Complete without client
+const DefaultFetchTimeout = time.Hour
+
+// FetchRule marks all files below Prefix (relative to source folder) as external.
+// they are listed in fetch.txt with URL + remaining path instead of being stored in the bag
+type FetchRule struct {
+	Prefix string
+	URL    string
+}
+
+// item of fetch.txt
+type fetchItem struct {
+	url    string
+	length int64 // -1, if unknown
+	path   string
+}
+
+var fetchLineRegexp = regexp.MustCompile(`^(\S+)\s+(\S+)\s+(.+)$`)
+
+// SetFetchRules defines, which files are external
+func (bc *BagitCreator) SetFetchRules(rules []FetchRule) {
+	bc.fetchRules = rules
+}
+
+// fetchURL returns the url of an external file or an empty string
+func (bc *BagitCreator) fetchURL(filePath string) string {
+	for _, rule := range bc.fetchRules {
+		prefix := "/" + strings.Trim(filepath.ToSlash(rule.Prefix), "/") + "/"
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(filePath, prefix), "/")
+		for key, part := range parts {
+			parts[key] = url.PathEscape(part)
+		}
+		return strings.TrimRight(rule.URL, "/") + "/" + strings.Join(parts, "/")
+	}
+	return ""
+}
+
+// readFetch reads all items of fetch.txt with path as key
+func (bagit *Bagit) readFetch(report *Report, formal *bagFormal) (map[string]*fetchItem, error) {
+	items := map[string]*fetchItem{}
+	fp, err := bagit.openTagFile(report, formal, "fetch.txt")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return items, nil
+		}
+		return nil, emperror.Wrap(err, "cannot open fetch.txt")
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		found := fetchLineRegexp.FindStringSubmatch(line)
+		if found == nil {
+			report.Errorf(FindingFetch, "fetch.txt", "invalid line: %s", line)
+			continue
+		}
+		item := &fetchItem{url: found[1], length: -1, path: decodePath(formal.version, found[3])}
+		if found[2] != "-" {
+			if item.length, err = strconv.ParseInt(found[2], 10, 64); err != nil || item.length < 0 {
+				report.Errorf(FindingFetch, "fetch.txt", "invalid length: %s", line)
+				continue
+			}
+		}
+		if !validPayloadPath(item.path) {
+			report.Errorf(FindingFetch, "fetch.txt", "invalid payload path %s", item.path)
+			continue
+		}
+		items[item.path] = item
+	}
+	if err := scanner.Err(); err != nil {
+		report.Errorf(FindingReadError, "fetch.txt", "cannot read: %v", err)
+	}
+	return items, nil
+}
+
+// validPayloadPath checks, that name is a clean path within the payload folder,
+// so that it cannot be used to write outside of the bag
+func validPayloadPath(name string) bool {
+	return fs.ValidPath(name) && path.Clean(name) == name && strings.HasPrefix(name, "data/")
+}
+
+// readManifest reads all checksums of a payload manifest with path as key
+func (bagit *Bagit) readManifest(report *Report, formal *bagFormal, checksum string) (map[string]string, error) {
+	manifest := fmt.Sprintf("manifest-%s.txt", checksum)
+	fp, err := bagit.openTagFile(report, formal, manifest)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot open %s", manifest)
+	}
+	defer fp.Close()
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		found := manifestLineRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if found == nil {
+			continue
+		}
+		sums[decodePath(formal.version, found[2])] = strings.ToLower(found[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, emperror.Wrapf(err, "cannot read %s", manifest)
+	}
+	return sums, nil
+}
+
+// fetch loads an item to a temporary file and calculates its checksums
+func fetch(item *fetchItem, tempdir string, client *http.Client, checksums []string) (string, map[string]string, error) {
+	u, err := url.Parse(item.url)
+	if err != nil {
+		return "", nil, emperror.Wrapf(err, "cannot parse url %s", item.url)
+	}
+	var reader io.ReadCloser
+	switch u.Scheme {
+	case "file":
+		if reader, err = os.Open(filepath.FromSlash(u.Path)); err != nil {
+			return "", nil, emperror.Wrapf(err, "cannot open %s", u.Path)
+		}
+	case "http", "https":
+		resp, err := client.Get(item.url)
+		if err != nil {
+			return "", nil, emperror.Wrapf(err, "cannot get %s", item.url)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", nil, errors.New(fmt.Sprintf("cannot get %s: %s", item.url, resp.Status))
+		}
+		reader = resp.Body
+	default:
+		return "", nil, errors.New(fmt.Sprintf("unsupported url scheme %s", u.Scheme))
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(tempdir, "bagarc-fetch-*")
+	if err != nil {
+		return "", nil, emperror.Wrapf(err, "cannot create temporary file in %s", tempdir)
+	}
+	defer tmp.Close()
+	sums, err := ChecksumCopy(tmp, reader, checksums)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, emperror.Wrapf(err, "cannot load %s", item.url)
+	}
+	if item.length >= 0 {
+		stat, err := tmp.Stat()
+		if err != nil {
+			os.Remove(tmp.Name())
+			return "", nil, emperror.Wrapf(err, "cannot stat %s", tmp.Name())
+		}
+		if stat.Size() != item.length {
+			os.Remove(tmp.Name())
+			return "", nil, errors.New(fmt.Sprintf("invalid length %v <> %v", stat.Size(), item.length))
+		}
+	}
+	return tmp.Name(), sums, nil
+}
+
+// Complete fetches all items of fetch.txt, which are not in the bag, verifies them against
+// the payload manifests and adds them to the bag. zip and tar bags are written again.
+// if client is nil, a client with DefaultFetchTimeout is used
+func (bagit *Bagit) Complete(tempdir string, client *http.Client) (*Report, error) {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultFetchTimeout}
+	}
+	report := NewReport(bagit.bagitfile)
+	formal, err := bagit.checkFormal(report)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "error running pass #1")
+	}
+	if len(formal.checksums) == 0 {
+		return report, nil
+	}
+	manifests := map[string]map[string]string{}
+	for _, checksum := range formal.checksums {
+		if manifests[checksum], err = bagit.readManifest(report, formal, checksum); err != nil {
+			return nil, err
+		}
+	}
+	present := map[string]bool{}
+	for _, entry := range formal.entries {
+		present[entry.name] = true
+	}
+	paths := []string{}
+	for name := range formal.fetch {
+		if !present[name] {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+
+	// fetched files with path as key
+	fetched := map[string]string{}
+	defer func() {
+		for _, tmpname := range fetched {
+			os.Remove(tmpname)
+		}
+	}()
+	for _, name := range paths {
+		item := formal.fetch[name]
+		bagit.logger.Infof("fetching %s from %s", name, item.url)
+		tmpname, sums, err := fetch(item, tempdir, client, formal.checksums)
+		if err != nil {
+			report.Errorf(FindingFetch, name, "%v", err)
+			continue
+		}
+		ok := true
+		for checksum, sum := range sums {
+			expected, listed := manifests[checksum][name]
+			if !listed {
+				report.Errorf(FindingFetch, name, "not listed in manifest-%s.txt", checksum)
+				ok = false
+			} else if expected != sum {
+				report.Errorf(FindingChecksumMismatch, name, "invalid checksum %s <> %s in manifest-%s.txt", sum, expected, checksum)
+				ok = false
+			}
+		}
+		if !ok {
+			os.Remove(tmpname)
+			continue
+		}
+		report.Files++
+		fetched[name] = tmpname
+	}
+	if len(fetched) == 0 {
+		return report, nil
+	}
+	if err := bagit.addFiles(formal.entries, fetched); err != nil {
+		return nil, emperror.Wrapf(err, "cannot add fetched files to %s", bagit.bagitfile)
+	}
+	return report, nil
+}
+
+// addFiles adds files to the bag. folders are extended, all other containers are written again
+func (bagit *Bagit) addFiles(entries []*bagEntry, files map[string]string) error {
+	var bagWriter BagWriter
+	var target string
+	if BagFormat(bagit.bagitfile) == FormatFolder {
+		bagWriter = &DirBagWriter{folder: bagit.bagitfile}
+	} else {
+		var err error
+		target = updateTarget(bagit.bagitfile)
+		if bagWriter, err = NewBagWriter(target); err != nil {
+			return err
+		}
+		zipFiles := zipFiles(bagit.fsys)
+		for _, entry := range entries {
+			if err := copyEntry(bagit.fsys, zipFiles, entry.name, entry.name, bagWriter); err != nil {
+				bagWriter.Close()
+				return err
+			}
+		}
+	}
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := copyEntry(os.DirFS(filepath.Dir(files[name])), nil, filepath.Base(files[name]), name, bagWriter); err != nil {
+			bagWriter.Close()
+			return err
+		}
+	}
+	if err := bagWriter.Close(); err != nil {
+		return emperror.Wrapf(err, "cannot close bag")
+	}
+	if target == "" {
+		return nil
+	}
+	// replace bag
+	if err := bagit.Close(); err != nil {
+		return emperror.Wrapf(err, "cannot close %s", bagit.bagitfile)
+	}
+	if err := os.Rename(target, bagit.bagitfile); err != nil {
+		return emperror.Wrapf(err, "cannot rename %s", target)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package bagit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var fetchTestFiles = map[string]string{
+	"local.txt":     "stored within the bag",
+	"ext/a.txt":     "first external file",
+	"ext/sub/b.txt": "second external file",
+	"ext/c.txt":     "third external file",
+}
+
+// createHoleyBag creates a bag of fetchTestFiles with the files of ext/ in fetch.txt.
+// the url of ext/ is returned by serve
+func createHoleyBag(t *testing.T, bagname string, serve func(extDir string) string) (string, string) {
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, fetchTestFiles)
+	extDir := filepath.Join(sourcedir, "ext")
+	bagfile := filepath.Join(t.TempDir(), bagname)
+	createTestBag(t, sourcedir, bagfile, func(bc *BagitCreator) {
+		bc.SetFetchRules([]FetchRule{{Prefix: "ext", URL: serve(extDir)}})
+	})
+	return bagfile, extDir
+}
+
+func serveHTTP(t *testing.T) func(string) string {
+	return func(extDir string) string {
+		server := httptest.NewServer(http.FileServer(http.Dir(extDir)))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+}
+
+func serveFile(extDir string) string {
+	return "file:///" + strings.TrimPrefix(filepath.ToSlash(extDir), "/")
+}
+
+func completeBag(t *testing.T, bagfile string) *Report {
+	t.Helper()
+	checker, err := NewBagit(bagfile, t.TempDir(), nil, testLogger)
+	if err != nil {
+		t.Fatalf("cannot open %s: %v", bagfile, err)
+	}
+	defer checker.Close()
+	report, err := checker.Complete(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("cannot complete %s: %v", bagfile, err)
+	}
+	return report
+}
+
+func TestComplete(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		bagname string
+		serve   func(t *testing.T) func(string) string
+	}{
+		{name: "http folder", bagname: "bag", serve: serveHTTP},
+		{name: "http zip", bagname: "bag.zip", serve: serveHTTP},
+		{name: "file folder", bagname: "bag", serve: func(*testing.T) func(string) string { return serveFile }},
+		{name: "file zip", bagname: "bag.zip", serve: func(*testing.T) func(string) string { return serveFile }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			bagfile, _ := createHoleyBag(t, test.bagname, test.serve(t))
+			report := completeBag(t, bagfile)
+			if err := report.Err(); err != nil {
+				t.Fatalf("complete failed: %v", err)
+			}
+			if report.Files != 3 {
+				t.Errorf("%v files fetched, expected 3", report.Files)
+			}
+			validateTestBag(t, bagfile)
+
+			// nothing left to fetch
+			if report := completeBag(t, bagfile); report.Files != 0 || report.HasErrors() {
+				t.Errorf("second complete fetched %v files with findings %v", report.Files, report.Findings)
+			}
+		})
+	}
+}
+
+func TestCompleteInvalid(t *testing.T) {
+	bagfile, extDir := createHoleyBag(t, "bag", serveHTTP(t))
+
+	// same length, other content
+	writeTestTree(t, extDir, map[string]string{"a.txt": strings.ToUpper(fetchTestFiles["ext/a.txt"])})
+	// other length
+	writeTestTree(t, extDir, map[string]string{"sub/b.txt": fetchTestFiles["ext/sub/b.txt"] + " changed"})
+	// remove from manifest
+	manifest := filepath.Join(bagfile, "manifest-sha512.txt")
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("cannot read manifest: %v", err)
+	}
+	lines := []string{}
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if !strings.HasSuffix(strings.TrimSpace(line), "data/ext/c.txt") {
+			lines = append(lines, line)
+		}
+	}
+	if err := os.WriteFile(manifest, []byte(strings.Join(lines, "")), 0644); err != nil {
+		t.Fatalf("cannot write manifest: %v", err)
+	}
+
+	report := completeBag(t, bagfile)
+	expected := map[string]struct {
+		fType   FindingType
+		message string
+	}{
+		"data/ext/a.txt":     {FindingChecksumMismatch, "invalid checksum"},
+		"data/ext/sub/b.txt": {FindingFetch, "invalid length"},
+		"data/ext/c.txt":     {FindingFetch, "not listed in manifest-sha512.txt"},
+	}
+	for _, finding := range report.Findings {
+		exp, ok := expected[finding.File]
+		if !ok {
+			t.Errorf("unexpected finding %s", finding)
+			continue
+		}
+		if finding.Severity != SeverityError || finding.Type != exp.fType || !strings.Contains(finding.Message, exp.message) {
+			t.Errorf("finding %s, expected %s: %s", finding, exp.fType, exp.message)
+		}
+		delete(expected, finding.File)
+	}
+	for name, exp := range expected {
+		t.Errorf("no finding for %s, expected %s", name, exp.fType)
+	}
+	if report.Files != 0 {
+		t.Errorf("%v invalid files added", report.Files)
+	}
+	for name := range fetchTestFiles {
+		if !strings.HasPrefix(name, "ext/") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(bagfile, "data", filepath.FromSlash(name))); err == nil {
+			t.Errorf("invalid file %s added to bag", name)
+		}
+	}
+}
+
+// paths of fetch.txt must not point outside of the payload folder
+func TestCompleteMaliciousPath(t *testing.T) {
+	bagfile, extDir := createHoleyBag(t, "bag", serveFile)
+	data, err := os.ReadFile(filepath.Join(bagfile, "manifest-sha512.txt"))
+	if err != nil {
+		t.Fatalf("cannot read manifest: %v", err)
+	}
+	var checksum string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasSuffix(line, " data/ext/a.txt") {
+			checksum = strings.Fields(line)[0]
+		}
+	}
+	url := serveFile(extDir) + "/a.txt"
+	length := len(fetchTestFiles["ext/a.txt"])
+	fetchLines, manifestLines := "", ""
+	invalid := []string{"data/../../escape.txt", "data/ext/../../../escape.txt", "data/./escape.txt", "data//escape.txt", "/data/escape.txt", "escape.txt"}
+	for _, name := range invalid {
+		fetchLines += fmt.Sprintf("%s %v %s\n", url, length, name)
+		manifestLines += fmt.Sprintf("%s  %s\n", checksum, name)
+	}
+	for name, lines := range map[string]string{"fetch.txt": fetchLines, "manifest-sha512.txt": manifestLines} {
+		fp, err := os.OpenFile(filepath.Join(bagfile, name), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("cannot open %s: %v", name, err)
+		}
+		if _, err := fp.WriteString(lines); err != nil {
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+		fp.Close()
+	}
+
+	report := completeBag(t, bagfile)
+	if report.Files != 3 {
+		t.Errorf("%v files fetched, expected 3", report.Files)
+	}
+	for _, name := range invalid {
+		found := false
+		for _, finding := range report.Findings {
+			if finding.Type == FindingFetch && finding.File == "fetch.txt" && strings.HasSuffix(finding.Message, " "+name) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no finding for %s", name)
+		}
+	}
+	for _, name := range []string{filepath.Join(filepath.Dir(bagfile), "escape.txt"), filepath.Join(bagfile, "escape.txt"), filepath.Join(bagfile, "data", "escape.txt")} {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("%s written", name)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package bagit
+
+import (
+	"github.com/goph/emperror"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pattern is a compiled gitignore style pattern
+type pattern struct {
+	source  string
+	negate  bool // "!" re-includes a file
+	dirOnly bool // trailing "/" matches only directories
+	re      *regexp.Regexp
+}
+
+// compilePattern converts a gitignore style pattern to a regular expression.
+// patterns without "/" match at any level, "**" matches any number of folders
+func compilePattern(source string) (*pattern, error) {
+	p := &pattern{source: source}
+	str := strings.TrimRight(source, " ")
+	if strings.HasPrefix(str, "!") {
+		p.negate = true
+		str = str[1:]
+	}
+	if strings.HasSuffix(str, "/") {
+		p.dirOnly = true
+		str = strings.TrimRight(str, "/")
+	}
+	anchored := strings.Contains(str, "/")
+	str = strings.TrimPrefix(str, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(.*/)?"
+	}
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '*':
+			if strings.HasPrefix(str[i:], "**") {
+				switch {
+				case strings.HasPrefix(str[i:], "**/"):
+					expr += "(.*/)?"
+					i += 2
+				default:
+					expr += ".*"
+					i++
+				}
+				continue
+			}
+			expr += "[^/]*"
+		case '?':
+			expr += "[^/]"
+		case '[':
+			end := strings.Index(str[i+1:], "]")
+			if end < 0 {
+				expr += regexp.QuoteMeta(string(c))
+				continue
+			}
+			class := str[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr += "[" + strings.ReplaceAll(class, `\`, `\\`) + "]"
+			i += end + 1
+		case '\\':
+			if i+1 < len(str) {
+				i++
+			}
+			expr += regexp.QuoteMeta(string(str[i]))
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+	expr += "$"
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "invalid pattern %s", source)
+	}
+	p.re = re
+	return p, nil
+}
+
+func (p *pattern) match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return p.re.MatchString(name)
+}
+
+// Filter selects the files of the source folder with gitignore style patterns.
+// names are slash separated and relative to the source folder
+type Filter struct {
+	include []*pattern
+	exclude []*pattern
+}
+
+// NewFilter compiles include and exclude patterns. empty lines and comments (#) are ignored.
+// without include patterns, all files are included
+func NewFilter(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, list := range []struct {
+		sources  []string
+		patterns *[]*pattern
+	}{{include, &f.include}, {exclude, &f.exclude}} {
+		for _, source := range list.sources {
+			if strings.TrimSpace(source) == "" || strings.HasPrefix(source, "#") {
+				continue
+			}
+			p, err := compilePattern(source)
+			if err != nil {
+				return nil, err
+			}
+			*list.patterns = append(*list.patterns, p)
+		}
+	}
+	return f, nil
+}
+
+// Excluded returns the exclude pattern, which matches name or an empty string.
+// like in .gitignore, the last matching pattern wins
+func (f *Filter) Excluded(name string, isDir bool) string {
+	var result string
+	for _, p := range f.exclude {
+		if p.match(name, isDir) {
+			if p.negate {
+				result = ""
+			} else {
+				result = p.source
+			}
+		}
+	}
+	return result
+}
+
+// Included checks the include patterns against the file and its folders
+func (f *Filter) Included(name string) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+	for dir, isDir := name, false; dir != "." && dir != "/" && dir != ""; dir, isDir = path.Dir(dir), true {
+		for _, p := range f.include {
+			if !p.negate && p.match(dir, isDir) {
+				return true
+			}
+		}
+	}
+	return false
+}
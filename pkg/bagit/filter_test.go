@@ -0,0 +1,158 @@
+package bagit
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		isDir   bool
+		match   bool
+	}{
+		// without "/" at any level
+		{"*.tmp", "a.tmp", false, true},
+		{"*.tmp", "sub/dir/a.tmp", false, true},
+		{"*.tmp", "a.tmp.txt", false, false},
+		{"*.tmp", "sub/a.txt", false, false},
+		{"Thumbs.db", "photos/Thumbs.db", false, true},
+		{"?.txt", "a.txt", false, true},
+		{"?.txt", "ab.txt", false, false},
+		// "*" does not cross folders
+		{"sub/*.txt", "sub/a.txt", false, true},
+		{"sub/*.txt", "sub/x/a.txt", false, false},
+		// anchored
+		{"/a.txt", "a.txt", false, true},
+		{"/a.txt", "sub/a.txt", false, false},
+		{"sub/a.txt", "sub/a.txt", false, true},
+		{"sub/a.txt", "x/sub/a.txt", false, false},
+		// "**"
+		{"**/cache", "cache", true, true},
+		{"**/cache", "a/b/cache", true, true},
+		{"a/**/b.txt", "a/b.txt", false, true},
+		{"a/**/b.txt", "a/x/y/b.txt", false, true},
+		{"a/**/b.txt", "x/a/b.txt", false, false},
+		{"a/**", "a/x/y.txt", false, true},
+		{"a/**", "b/x/y.txt", false, false},
+		// trailing "/" only matches folders
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "sub/build", true, true},
+		// character classes
+		{"*.[ch]", "main.c", false, true},
+		{"*.[ch]", "main.h", false, true},
+		{"*.[ch]", "main.o", false, false},
+		{"file[0-9].txt", "file5.txt", false, true},
+		{"file[0-9].txt", "filex.txt", false, false},
+		{"file[!0-9].txt", "filex.txt", false, true},
+		{"file[!0-9].txt", "file5.txt", false, false},
+		{"a[.txt", "a[.txt", false, true},
+		// escapes
+		{`\!important`, "!important", false, true},
+		{`a\*.txt`, "a*.txt", false, true},
+		{`a\*.txt`, "ab.txt", false, false},
+		// regexp meta characters are literals
+		{"a+b.txt", "a+b.txt", false, true},
+		{"a+b.txt", "aab.txt", false, false},
+		// trailing spaces are ignored
+		{"*.bak  ", "a.bak", false, true},
+	} {
+		p, err := compilePattern(test.pattern)
+		if err != nil {
+			t.Errorf("cannot compile %s: %v", test.pattern, err)
+			continue
+		}
+		if match := p.match(test.name, test.isDir); match != test.match {
+			t.Errorf("%s on %s (folder %v): match %v, expected %v", test.pattern, test.name, test.isDir, match, test.match)
+		}
+	}
+
+	p, err := compilePattern("!*.log")
+	if err != nil {
+		t.Fatalf("cannot compile !*.log: %v", err)
+	}
+	if !p.negate || !p.match("sub/a.log", false) {
+		t.Errorf("!*.log: negate %v, match %v", p.negate, p.match("sub/a.log", false))
+	}
+}
+
+// exclude patterns take precedence over include patterns
+func TestFilter(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, map[string]string{
+		"images/a.jpg":       "a",
+		"images/sub/b.jpg":   "b",
+		"images/raw/c.cr2":   "c",
+		"images/debug.log":   "d",
+		"images/keep.log":    "e",
+		"docs/f.pdf":         "f",
+		"docs/f.txt":         "g",
+		"docs/secret.pdf":    "h",
+		"tmp/i.pdf":          "i",
+		"tmp/images/j.jpg":   "j",
+		"other/images/k.jpg": "k",
+	})
+	filter, err := NewFilter(
+		[]string{"# images and documents", "", "images/", "*.pdf"},
+		[]string{"*.log", "!keep.log", "tmp/", "images/raw/", "secret.pdf"},
+	)
+	if err != nil {
+		t.Fatalf("cannot create filter: %v", err)
+	}
+	bc := newTestCreator(t, sourcedir, filepath.Join(t.TempDir(), "bag.zip"))
+	bc.SetFilter(filter)
+	entries, err := bc.scanSource()
+	if err != nil {
+		t.Fatalf("cannot scan %s: %v", sourcedir, err)
+	}
+	files := map[string]bool{}
+	for _, entry := range entries {
+		if entry.info.IsDir() {
+			continue
+		}
+		rel, err := filepath.Rel(sourcedir, entry.path)
+		if err != nil {
+			t.Fatalf("cannot get relative path of %s: %v", entry.path, err)
+		}
+		files[filepath.ToSlash(rel)] = true
+	}
+	reasons := map[string]string{}
+	for _, excluded := range bc.excluded {
+		reasons[excluded.name] = excluded.reason
+	}
+	for name, reason := range map[string]string{
+		"images/a.jpg":       "",
+		"images/sub/b.jpg":   "",
+		"images/raw/c.cr2":   "exclude pattern images/raw/",
+		"images/debug.log":   "exclude pattern *.log",
+		"images/keep.log":    "",
+		"docs/f.pdf":         "",
+		"docs/f.txt":         "no include pattern",
+		"docs/secret.pdf":    "exclude pattern secret.pdf",
+		"tmp/i.pdf":          "exclude pattern tmp/",
+		"tmp/images/j.jpg":   "exclude pattern tmp/",
+		"other/images/k.jpg": "",
+	} {
+		if reason == "" && !files[name] {
+			t.Errorf("%s not included: %s", name, reasons[name])
+		}
+		if reason != "" && (files[name] || reasons[name] != reason) {
+			t.Errorf("%s included %v with reason %q, expected %q", name, files[name], reasons[name], reason)
+		}
+	}
+
+	// without include patterns, everything is included
+	filter, err = NewFilter(nil, []string{"*.log"})
+	if err != nil {
+		t.Fatalf("cannot create filter: %v", err)
+	}
+	if !filter.Included("any/file.txt") {
+		t.Errorf("any/file.txt not included without include patterns")
+	}
+
+	if _, err := NewFilter([]string{"[z-a]"}, nil); err == nil {
+		t.Errorf("no error with invalid character class")
+	}
+}
@@ -2,4 +2,5 @@ package bagit
 
 const NAME = "bagarc 0.7, info-age GmbH Basel <https://github.com/je4/bagarc>"
 const VERSION = "0.8"
-const BAGITVERSION = "0.97"
+const BAGITVERSION = "0.97" // default version of created bags
+const BAGITVERSION1 = "1.0" // RFC 8493
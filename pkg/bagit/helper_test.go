@@ -0,0 +1,89 @@
+package bagit
+
+import (
+	"github.com/dgraph-io/badger"
+	"github.com/op/go-logging"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testLogger = func() *logging.Logger {
+	backend := logging.AddModuleLevel(logging.NewLogBackend(io.Discard, "", 0))
+	backend.SetLevel(logging.ERROR, "")
+	logging.SetBackend(backend)
+	return logging.MustGetLogger("bagit_test")
+}()
+
+// writeTestTree creates files with content below dir. keys are slash separated paths
+func writeTestTree(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fullpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fullpath), 0755); err != nil {
+			t.Fatalf("cannot create folder for %s: %v", name, err)
+		}
+		if err := os.WriteFile(fullpath, []byte(content), 0644); err != nil {
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+	}
+}
+
+// newTestCreator returns a creator with sha512 checksums and a database, which is closed at the end of the test
+func newTestCreator(t testing.TB, sourcedir, bagfile string) *BagitCreator {
+	t.Helper()
+	tempdir := t.TempDir()
+	db := openTestDB(t, tempdir)
+	t.Cleanup(func() { db.Close() })
+	return newTestCreatorDB(t, sourcedir, bagfile, tempdir, db)
+}
+
+// openTestDB opens a badger database in dir
+func openTestDB(t testing.TB, dir string) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(filepath.Join(dir, "badger")).WithLogger(testLogger))
+	if err != nil {
+		t.Fatalf("cannot open badger database: %v", err)
+	}
+	return db
+}
+
+// newTestCreatorDB returns a creator with sha512 checksums, which uses db and tempdir
+func newTestCreatorDB(t testing.TB, sourcedir, bagfile, tempdir string, db *badger.DB) *BagitCreator {
+	t.Helper()
+	bc, err := NewBagitCreator(sourcedir, bagfile, []string{"sha512"}, map[string]string{}, db, false, nil, "", nil, tempdir, nil, testLogger)
+	if err != nil {
+		t.Fatalf("cannot create BagitCreator: %v", err)
+	}
+	return bc
+}
+
+// createTestBag creates a bag of sourcedir. setup may change the creator before running
+func createTestBag(t testing.TB, sourcedir, bagfile string, setup func(bc *BagitCreator)) {
+	t.Helper()
+	bc := newTestCreator(t, sourcedir, bagfile)
+	if setup != nil {
+		setup(bc)
+	}
+	if err := bc.Run(); err != nil {
+		t.Fatalf("cannot create bag %s: %v", bagfile, err)
+	}
+}
+
+// validateTestBag fails on any error finding of the bag
+func validateTestBag(t testing.TB, bagfile string) {
+	t.Helper()
+	checker, err := NewBagit(bagfile, t.TempDir(), nil, testLogger)
+	if err != nil {
+		t.Fatalf("cannot open %s: %v", bagfile, err)
+	}
+	defer checker.Close()
+	report, err := checker.Validate(nil, nil)
+	if err != nil {
+		t.Fatalf("cannot validate %s: %v", bagfile, err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("%s invalid: %v", bagfile, err)
+	}
+}
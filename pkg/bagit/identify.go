@@ -0,0 +1,223 @@
+package bagit
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/goph/emperror"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileFormat is the format of a file with PRONOM id
+type FileFormat struct {
+	Id     string `json:"id"` // PRONOM id or UNKNOWN
+	Format string `json:"format"`
+	Mime   string `json:"mime,omitempty"`
+	Basis  string `json:"basis,omitempty"` // reason for identification
+}
+
+// Identifier detects the format of a file. unknown formats are nil
+type Identifier interface {
+	Identify(fullpath string) (*FileFormat, error)
+}
+
+// MagicIdentifier identifies common archival formats by signature without external service.
+// formats without signature (text, csv, xml, json) are identified by extension
+type MagicIdentifier struct{}
+
+func NewMagicIdentifier() *MagicIdentifier {
+	return &MagicIdentifier{}
+}
+
+// number of bytes needed for the signatures
+const identifyHeadSize = 512
+
+// formats without signature
+var extensionFormats = map[string]FileFormat{
+	"txt":  {Id: "x-fmt/111", Format: "Plain Text File", Mime: "text/plain"},
+	"csv":  {Id: "x-fmt/18", Format: "Comma Separated Values", Mime: "text/csv"},
+	"xml":  {Id: "fmt/101", Format: "Extensible Markup Language", Mime: "application/xml"},
+	"json": {Id: "fmt/817", Format: "JSON Data Interchange Format", Mime: "application/json"},
+}
+
+var pdfVersions = map[string]string{
+	"1.0": "fmt/14",
+	"1.1": "fmt/15",
+	"1.2": "fmt/16",
+	"1.3": "fmt/17",
+	"1.4": "fmt/18",
+	"1.5": "fmt/19",
+	"1.6": "fmt/20",
+	"1.7": "fmt/276",
+	"2.0": "fmt/1129",
+}
+
+// office 97-2003 formats in OLE2 compound documents
+var ole2Formats = map[string]FileFormat{
+	"doc": {Id: "fmt/40", Format: "Microsoft Word Document", Mime: "application/msword"},
+	"xls": {Id: "fmt/61", Format: "Microsoft Excel 97 Workbook (xls)", Mime: "application/vnd.ms-excel"},
+	"ppt": {Id: "fmt/126", Format: "Microsoft Powerpoint Presentation", Mime: "application/vnd.ms-powerpoint"},
+}
+
+// office open xml formats by folder in zip
+var ooxmlFormats = map[string]FileFormat{
+	"word/": {Id: "fmt/412", Format: "Microsoft Word for Windows", Mime: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	"xl/":   {Id: "fmt/214", Format: "Microsoft Excel for Windows", Mime: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	"ppt/":  {Id: "fmt/215", Format: "Microsoft Powerpoint for Windows", Mime: "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+}
+
+// Identify checks the signatures of the first bytes, zip files are opened to detect office open xml
+func (mi *MagicIdentifier) Identify(fullpath string) (*FileFormat, error) {
+	fp, err := os.Open(fullpath)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot open %v", fullpath)
+	}
+	defer fp.Close()
+	head := make([]byte, identifyHeadSize)
+	n, err := io.ReadFull(fp, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, emperror.Wrapf(err, "cannot read %v", fullpath)
+	}
+	head = head[:n]
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fullpath), "."))
+
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		version := string(head[5:])
+		if len(version) > 3 {
+			version = version[:3]
+		}
+		id, ok := pdfVersions[version]
+		if !ok {
+			id = "UNKNOWN"
+		}
+		return &FileFormat{Id: id, Format: "Acrobat PDF " + version, Mime: "application/pdf", Basis: "signature %PDF-" + version}, nil
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return &FileFormat{Id: "fmt/353", Format: "Tagged Image File Format", Mime: "image/tiff", Basis: "signature"}, nil
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return identifyJPEG(head), nil
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return &FileFormat{Id: "fmt/11", Format: "Portable Network Graphics", Mime: "image/png", Basis: "signature"}, nil
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return identifyWAVE(head), nil
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		brand := string(head[8:12])
+		if brand == "qt  " {
+			return &FileFormat{Id: "x-fmt/384", Format: "Quicktime", Mime: "video/quicktime", Basis: "ftyp brand qt"}, nil
+		}
+		mime := "video/mp4"
+		if brand == "M4A " {
+			mime = "audio/mp4"
+		}
+		return &FileFormat{Id: "fmt/199", Format: "MPEG-4 Media File", Mime: mime, Basis: fmt.Sprintf("ftyp brand %s", strings.TrimSpace(brand))}, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		stat, err := fp.Stat()
+		if err != nil {
+			return nil, emperror.Wrapf(err, "cannot stat %v", fullpath)
+		}
+		return identifyZip(fp, stat.Size()), nil
+	case bytes.HasPrefix(head, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")):
+		if format, ok := ole2Formats[ext]; ok {
+			format.Basis = "OLE2 signature and extension"
+			return &format, nil
+		}
+		return &FileFormat{Id: "fmt/111", Format: "OLE2 Compound Document Format", Basis: "signature"}, nil
+	}
+	if format, ok := extensionFormats[ext]; ok {
+		format.Basis = "extension"
+		return &format, nil
+	}
+	return nil, nil
+}
+
+// identifyJPEG uses the version of JFIF. other JPEGs are raw streams
+func identifyJPEG(head []byte) *FileFormat {
+	if len(head) >= 13 && string(head[6:11]) == "JFIF\x00" {
+		version := fmt.Sprintf("%d.%02d", head[11], head[12])
+		if id, ok := map[string]string{"1.00": "fmt/42", "1.01": "fmt/43", "1.02": "fmt/44"}[version]; ok {
+			return &FileFormat{Id: id, Format: "JPEG File Interchange Format " + version, Mime: "image/jpeg", Basis: "signature JFIF " + version}
+		}
+	}
+	return &FileFormat{Id: "fmt/41", Format: "Raw JPEG Stream", Mime: "image/jpeg", Basis: "signature"}
+}
+
+// identifyWAVE checks the chunks for broadcast extension and the size of the format chunk
+func identifyWAVE(head []byte) *FileFormat {
+	format := &FileFormat{Id: "fmt/141", Format: "Waveform Audio (PCMWAVEFORMAT)", Mime: "audio/x-wav", Basis: "signature"}
+	for pos := 12; pos+8 <= len(head); {
+		id := string(head[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(head[pos+4:]))
+		data := head[pos+8:]
+		switch id {
+		case "bext":
+			// version follows description, originator, reference, date, time and time reference
+			if len(data) >= 348 {
+				version := binary.LittleEndian.Uint16(data[346:])
+				if bwf, ok := map[uint16]string{0: "fmt/1", 1: "fmt/2", 2: "fmt/703"}[version]; ok {
+					return &FileFormat{Id: bwf, Format: fmt.Sprintf("Broadcast WAVE %v", version), Mime: "audio/x-wav", Basis: "bext chunk"}
+				}
+			}
+		case "fmt ":
+			switch size {
+			case 18:
+				format.Id, format.Format = "fmt/142", "Waveform Audio (WAVEFORMATEX)"
+			case 40:
+				format.Id, format.Format = "fmt/143", "Waveform Audio (WAVEFORMATEXTENSIBLE)"
+			}
+		}
+		if size > len(data) {
+			break
+		}
+		pos += 8 + size + size%2
+	}
+	return format
+}
+
+// identifyZip looks for office open xml folders
+func identifyZip(r io.ReaderAt, size int64) *FileFormat {
+	format := &FileFormat{Id: "x-fmt/263", Format: "ZIP Format", Mime: "application/zip", Basis: "signature"}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return format
+	}
+	contentTypes := false
+	folders := map[string]bool{}
+	for _, f := range zr.File {
+		if f.Name == "[Content_Types].xml" {
+			contentTypes = true
+		}
+		if i := strings.Index(f.Name, "/"); i > 0 {
+			folders[f.Name[:i+1]] = true
+		}
+	}
+	if !contentTypes {
+		return format
+	}
+	for _, folder := range []string{"word/", "xl/", "ppt/"} {
+		if ooxml := ooxmlFormats[folder]; folders[folder] {
+			ooxml.Basis = "zip with [Content_Types].xml and " + folder
+			return &ooxml
+		}
+	}
+	return format
+}
+
+// identify records the format of the identifier
+func (bc *BagitCreator) identify(bf *BagitFile) error {
+	if bc.identifier == nil {
+		return nil
+	}
+	format, err := bc.identifier.Identify(filepath.Join(bf.baseDir, bf.Path))
+	if err != nil {
+		return emperror.Wrapf(err, "cannot identify %s", bf)
+	}
+	if format != nil {
+		bc.logger.Debugf("%s format: %s (%s)", bf, format.Id, format.Format)
+	}
+	bf.Format = format
+	return nil
+}
@@ -0,0 +1,109 @@
+package bagit
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// zipContent returns a zip with empty files
+func zipContent(t *testing.T, names ...string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, name := range names {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatalf("cannot create %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// waveContent returns a RIFF header with a format chunk of fmtSize bytes
+func waveContent(fmtSize uint32) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	binary.Write(buf, binary.LittleEndian, fmtSize)
+	buf.Write(make([]byte, fmtSize))
+	buf.WriteString("data\x00\x00\x00\x00")
+	return buf.Bytes()
+}
+
+func TestMagicIdentifier(t *testing.T) {
+	ole2 := []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00\x00\x00\x00")
+	for _, test := range []struct {
+		name    string
+		content []byte
+		id      string // empty for unknown
+		mime    string
+	}{
+		{"a.pdf", []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), "fmt/18", "application/pdf"},
+		{"a.pdf", []byte("%PDF-1.7\n"), "fmt/276", "application/pdf"},
+		{"a.pdf", []byte("%PDF-9.9\n"), "UNKNOWN", "application/pdf"},
+		{"a.pdf", []byte("%PDF-"), "UNKNOWN", "application/pdf"},
+		{"a.tif", []byte("II*\x00\x08\x00\x00\x00"), "fmt/353", "image/tiff"},
+		{"a.tif", []byte("MM\x00*\x00\x00\x00\x08"), "fmt/353", "image/tiff"},
+		{"a.jpg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01"), "fmt/43", "image/jpeg"},
+		{"a.jpg", []byte("\xff\xd8\xff\xe1\x00\x10Exif\x00\x00"), "fmt/41", "image/jpeg"},
+		{"a.png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), "fmt/11", "image/png"},
+		{"a.wav", waveContent(16), "fmt/141", "audio/x-wav"},
+		{"a.wav", waveContent(18), "fmt/142", "audio/x-wav"},
+		{"a.wav", waveContent(40), "fmt/143", "audio/x-wav"},
+		// ftyp brands
+		{"a.mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), "fmt/199", "video/mp4"},
+		{"a.m4a", []byte("\x00\x00\x00\x18ftypM4A \x00\x00\x02\x00"), "fmt/199", "audio/mp4"},
+		{"a.mov", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00"), "x-fmt/384", "video/quicktime"},
+		// office open xml needs [Content_Types].xml and the folder of the application
+		{"a.docx", zipContent(t, "[Content_Types].xml", "_rels/.rels", "word/document.xml"), "fmt/412", ooxmlFormats["word/"].Mime},
+		{"a.xlsx", zipContent(t, "[Content_Types].xml", "xl/workbook.xml"), "fmt/214", ooxmlFormats["xl/"].Mime},
+		{"a.pptx", zipContent(t, "[Content_Types].xml", "ppt/presentation.xml"), "fmt/215", ooxmlFormats["ppt/"].Mime},
+		{"a.zip", zipContent(t, "word/document.xml"), "x-fmt/263", "application/zip"},
+		{"a.docx", zipContent(t, "[Content_Types].xml", "other/document.xml"), "x-fmt/263", "application/zip"},
+		{"a.zip", zipContent(t), "x-fmt/263", "application/zip"},
+		{"a.zip", []byte("PK\x03\x04 truncated"), "x-fmt/263", "application/zip"},
+		// office 97-2003 by extension
+		{"a.doc", ole2, "fmt/40", "application/msword"},
+		{"a.XLS", ole2, "fmt/61", "application/vnd.ms-excel"},
+		{"a.ppt", ole2, "fmt/126", "application/vnd.ms-powerpoint"},
+		{"a.msg", ole2, "fmt/111", ""},
+		// formats without signature
+		{"a.txt", []byte("plain text"), "x-fmt/111", "text/plain"},
+		{"a.CSV", []byte("a,b\n1,2\n"), "x-fmt/18", "text/csv"},
+		{"a.json", []byte("{}"), "fmt/817", "application/json"},
+		{"a.xml", []byte("<?xml version=\"1.0\"?><a/>"), "fmt/101", "application/xml"},
+		// the signature wins over the extension
+		{"a.txt", []byte("%PDF-1.4\n"), "fmt/18", "application/pdf"},
+		{"a.bin", []byte("\x00\x01\x02\x03"), "", ""},
+		{"empty.bin", []byte{}, "", ""},
+		{"empty.txt", []byte{}, "x-fmt/111", "text/plain"},
+	} {
+		fullpath := filepath.Join(t.TempDir(), test.name)
+		if err := os.WriteFile(fullpath, test.content, 0644); err != nil {
+			t.Fatalf("cannot write %s: %v", test.name, err)
+		}
+		format, err := NewMagicIdentifier().Identify(fullpath)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if test.id == "" {
+			if format != nil {
+				t.Errorf("%s identified as %+v", test.name, format)
+			}
+			continue
+		}
+		if format == nil {
+			t.Errorf("%s not identified, expected %s", test.name, test.id)
+			continue
+		}
+		if format.Id != test.id || format.Mime != test.mime || format.Basis == "" {
+			t.Errorf("%s identified as %+v, expected %s %s", test.name, format, test.id, test.mime)
+		}
+	}
+}
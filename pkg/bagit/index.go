@@ -0,0 +1,82 @@
+package bagit
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/goph/emperror"
+	"sync"
+)
+
+// ChecksumIndex stores the calculated checksums of the files within a bag
+type ChecksumIndex interface {
+	Set(checksum, name, value string) error
+	// Get returns false, if there's no checksum for the file
+	Get(checksum, name string) (string, bool, error)
+}
+
+// checksums are stored with key "<checksum>:<filename>"
+func checksumKey(checksum, name string) string {
+	return fmt.Sprintf("%s:%s", checksum, name)
+}
+
+// MemoryIndex keeps all checksums in a map
+type MemoryIndex struct {
+	sync.RWMutex
+	sums map[string]string
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{sums: map[string]string{}}
+}
+
+func (mi *MemoryIndex) Set(checksum, name, value string) error {
+	mi.Lock()
+	defer mi.Unlock()
+	mi.sums[checksumKey(checksum, name)] = value
+	return nil
+}
+
+func (mi *MemoryIndex) Get(checksum, name string) (string, bool, error) {
+	mi.RLock()
+	defer mi.RUnlock()
+	value, ok := mi.sums[checksumKey(checksum, name)]
+	return value, ok, nil
+}
+
+// BadgerIndex stores checksums in a badger database for really huge bags
+type BadgerIndex struct {
+	db *badger.DB
+}
+
+func NewBadgerIndex(db *badger.DB) *BadgerIndex {
+	return &BadgerIndex{db: db}
+}
+
+func (bi *BadgerIndex) Set(checksum, name, value string) error {
+	if err := bi.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(checksumKey(checksum, name)), []byte(value))
+	}); err != nil {
+		return emperror.Wrapf(err, "cannot store checksum of %s", name)
+	}
+	return nil
+}
+
+func (bi *BadgerIndex) Get(checksum, name string) (string, bool, error) {
+	var value string
+	if err := bi.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(checksumKey(checksum, name)))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			value = string(val)
+			return nil
+		})
+	}); err != nil {
+		if err == badger.ErrKeyNotFound {
+			return "", false, nil
+		}
+		return "", false, emperror.Wrapf(err, "cannot get checksum of %s", name)
+	}
+	return value, true, nil
+}
@@ -0,0 +1,54 @@
+package bagit
+
+import (
+	"github.com/goph/emperror"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// readMetadata records modification time, access time, permissions, owner and - if withXattrs -
+// extended attributes of the file
+func (bf *BagitFile) readMetadata(withXattrs bool) error {
+	fullpath := filepath.Join(bf.baseDir, bf.Path)
+	modTime := bf.info.ModTime()
+	bf.ModTime = &modTime
+	bf.Mode = bf.info.Mode().Perm()
+	if err := readPlatformMetadata(bf, fullpath, withXattrs); err != nil {
+		return emperror.Wrapf(err, "cannot read metadata of %s", fullpath)
+	}
+	return nil
+}
+
+// setMetadata copies the metadata of another record
+func (bf *BagitFile) setMetadata(from *BagitFile) {
+	bf.ModTime = from.ModTime
+	bf.ATime = from.ATime
+	bf.Mode = from.Mode
+	bf.UID = from.UID
+	bf.GID = from.GID
+	bf.Xattrs = from.Xattrs
+}
+
+// restoreMetadata sets the recorded metadata on an extracted file.
+// owner and extended attributes may fail without the necessary privileges
+func restoreMetadata(fullpath string, bf *BagitFile) error {
+	if bf.Mode != 0 {
+		if err := os.Chmod(fullpath, bf.Mode); err != nil {
+			return emperror.Wrapf(err, "cannot set permissions of %s", fullpath)
+		}
+	}
+	if err := restorePlatformMetadata(fullpath, bf); err != nil {
+		return err
+	}
+	if bf.ModTime != nil {
+		atime := time.Now()
+		if bf.ATime != nil {
+			atime = *bf.ATime
+		}
+		if err := os.Chtimes(fullpath, atime, *bf.ModTime); err != nil {
+			return emperror.Wrapf(err, "cannot set times of %s", fullpath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package bagit
+
+// only modification time and permissions are supported
+func readPlatformMetadata(bf *BagitFile, fullpath string, withXattrs bool) error {
+	return nil
+}
+
+func restorePlatformMetadata(fullpath string, bf *BagitFile) error {
+	return nil
+}
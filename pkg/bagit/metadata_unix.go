@@ -0,0 +1,68 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package bagit
+
+import (
+	"bytes"
+	"errors"
+	"github.com/goph/emperror"
+	"golang.org/x/sys/unix"
+	"os"
+	"time"
+)
+
+func readPlatformMetadata(bf *BagitFile, fullpath string, withXattrs bool) error {
+	var stat unix.Stat_t
+	if err := unix.Stat(fullpath, &stat); err != nil {
+		return emperror.Wrapf(err, "cannot stat %s", fullpath)
+	}
+	atime := time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+	uid, gid := int(stat.Uid), int(stat.Gid)
+	bf.ATime, bf.UID, bf.GID = &atime, &uid, &gid
+	if !withXattrs {
+		return nil
+	}
+
+	size, err := unix.Listxattr(fullpath, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return emperror.Wrapf(err, "cannot list extended attributes of %s", fullpath)
+	}
+	if size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Listxattr(fullpath, buf); err != nil {
+		return emperror.Wrapf(err, "cannot list extended attributes of %s", fullpath)
+	}
+	bf.Xattrs = map[string][]byte{}
+	for _, name := range bytes.Split(bytes.TrimRight(buf[:size], "\x00"), []byte{0}) {
+		vsize, err := unix.Getxattr(fullpath, string(name), nil)
+		if err != nil {
+			return emperror.Wrapf(err, "cannot read extended attribute %s of %s", name, fullpath)
+		}
+		value := make([]byte, vsize)
+		if vsize, err = unix.Getxattr(fullpath, string(name), value); err != nil {
+			return emperror.Wrapf(err, "cannot read extended attribute %s of %s", name, fullpath)
+		}
+		bf.Xattrs[string(name)] = value[:vsize]
+	}
+	return nil
+}
+
+func restorePlatformMetadata(fullpath string, bf *BagitFile) error {
+	// only root may change the owner
+	if bf.UID != nil && bf.GID != nil && os.Geteuid() == 0 {
+		if err := unix.Chown(fullpath, *bf.UID, *bf.GID); err != nil {
+			return emperror.Wrapf(err, "cannot set owner of %s", fullpath)
+		}
+	}
+	for name, value := range bf.Xattrs {
+		if err := unix.Setxattr(fullpath, name, value, 0); err != nil {
+			return emperror.Wrapf(err, "cannot set extended attribute %s of %s", name, fullpath)
+		}
+	}
+	return nil
+}
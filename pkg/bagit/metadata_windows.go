@@ -0,0 +1,21 @@
+//go:build windows
+
+package bagit
+
+import (
+	"syscall"
+	"time"
+)
+
+// windows has no owner ids and extended attributes
+func readPlatformMetadata(bf *BagitFile, fullpath string, withXattrs bool) error {
+	if data, ok := bf.info.Sys().(*syscall.Win32FileAttributeData); ok {
+		atime := time.Unix(0, data.LastAccessTime.Nanoseconds())
+		bf.ATime = &atime
+	}
+	return nil
+}
+
+func restorePlatformMetadata(fullpath string, bf *BagitFile) error {
+	return nil
+}
@@ -0,0 +1,191 @@
+package bagit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/goph/emperror"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PlanEntry is a file of the source folder with its name in the bag or a reason
+type PlanEntry struct {
+	Path    string `json:"path"`
+	ZipPath string `json:"zippath,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Plan describes a bag creation without writing anything
+type Plan struct {
+	Source       string       `json:"source"`
+	Bag          string       `json:"bag"`
+	Version      string       `json:"version"`
+	Checksums    []string     `json:"checksums"`
+	Files        int64        `json:"files"`
+	Size         int64        `json:"size"`
+	PayloadOxum  string       `json:"payloadoxum"`
+	External     int64        `json:"external"` // files in fetch.txt
+	Links        int64        `json:"links"`
+	EmptyDirs    int64        `json:"emptydirs"`
+	Renames      []*PlanEntry `json:"renames"`
+	Collisions   []*PlanEntry `json:"collisions"`
+	Uncompressed []*PlanEntry `json:"uncompressed"` // stored in zip bags by compression rules or minimum gain
+	Unreadable   []*PlanEntry `json:"unreadable"`
+	Rejected     []*PlanEntry `json:"rejected"` // names not allowed by the filename policy
+	Excluded     []*PlanEntry `json:"excluded"`
+}
+
+// Plan walks through the source folder like Run, but writes no bag and does not query the indexer.
+// PRONOM and MIME rules of compression are only checked with an identifier
+func (bc *BagitCreator) Plan() (*Plan, error) {
+	checksums := bc.checksum
+	if len(checksums) == 0 {
+		checksums = DefaultChecksums(bc.version)
+	}
+	plan := &Plan{
+		Source:       bc.sourcedir,
+		Bag:          bc.bagitfile,
+		Version:      bc.version,
+		Checksums:    checksums,
+		Renames:      []*PlanEntry{},
+		Collisions:   []*PlanEntry{},
+		Uncompressed: []*PlanEntry{},
+		Unreadable:   []*PlanEntry{},
+		Rejected:     []*PlanEntry{},
+		Excluded:     []*PlanEntry{},
+	}
+	entries, err := bc.scanSource()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range bc.excluded {
+		plan.Excluded = append(plan.Excluded, &PlanEntry{Path: e.name, Message: e.reason})
+	}
+
+	zipPaths, err := bc.resolveNames(entries, plan)
+	if err != nil {
+		return nil, err
+	}
+	zipBag := BagFormat(bc.bagitfile) == FormatZip
+	for _, entry := range entries {
+		if entry.info.IsDir() && (!bc.emptyDirs || !entry.empty) {
+			continue
+		}
+		bf, err := newBagitFileInfo(bc.sourcedir, entry.path, entry.info, bc.filenamePolicy, bc.filenameRules)
+		if err != nil {
+			continue
+		}
+		// rejected or collision
+		zipPath, ok := zipPaths[bf.Path]
+		if !ok {
+			continue
+		}
+		if zipPath != bf.Path {
+			plan.Renames = append(plan.Renames, &PlanEntry{Path: bf.Path, ZipPath: zipPath})
+		}
+		bf.ZipPath = zipPath
+
+		switch {
+		case bf.Type == FileTypeSymlink:
+			plan.Links++
+			continue
+		case bf.IsDir():
+			plan.EmptyDirs++
+			continue
+		}
+		if err := checkReadable(entry.path); err != nil {
+			plan.Unreadable = append(plan.Unreadable, &PlanEntry{Path: bf.Path, Message: err.Error()})
+			continue
+		}
+		plan.Files++
+		plan.Size += bf.Size
+		if bc.fetchURL(bf.Path) != "" {
+			plan.External++
+			continue
+		}
+		if err := bc.identify(bf); err != nil {
+			return nil, err
+		}
+		if zipBag {
+			compression, err := bc.selectCompression(bf)
+			if err != nil {
+				return nil, err
+			}
+			if compression.Method == CompressionStore {
+				plan.Uncompressed = append(plan.Uncompressed, &PlanEntry{Path: bf.Path, ZipPath: zipPath, Message: compression.String()})
+			}
+		}
+	}
+	plan.PayloadOxum = fmt.Sprintf("%v.%v", plan.Size, plan.Files)
+	return plan, nil
+}
+
+// checkReadable opens the file and reads the first byte
+func checkReadable(fullpath string) error {
+	fp, err := os.Open(fullpath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	if _, err := fp.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return emperror.Wrapf(err, "cannot read %s", filepath.Base(fullpath))
+	}
+	return nil
+}
+
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(p)
+}
+
+func (p *Plan) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "source: %s\nbag: %s\nversion: %s\nchecksums: %v\nfiles: %v (%v external)\nsize: %s\nPayload-Oxum: %s\nlinks: %v\nempty folders: %v\n",
+		p.Source, p.Bag, p.Version, p.Checksums, p.Files, p.External, humanize.Bytes(uint64(p.Size)), p.PayloadOxum, p.Links, p.EmptyDirs); err != nil {
+		return err
+	}
+	for _, list := range []struct {
+		name    string
+		entries []*PlanEntry
+	}{
+		{"renames", p.Renames},
+		{"collisions", p.Collisions},
+		{"uncompressed", p.Uncompressed},
+		{"unreadable", p.Unreadable},
+		{"rejected", p.Rejected},
+		{"excluded", p.Excluded},
+	} {
+		if _, err := fmt.Fprintf(w, "%s: %v\n", list.name, len(list.entries)); err != nil {
+			return err
+		}
+		for _, e := range list.entries {
+			line := "    " + e.Path
+			if e.ZipPath != "" && e.ZipPath != e.Path {
+				line += " -> " + e.ZipPath
+			}
+			if e.Message != "" {
+				line += " - " + e.Message
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write writes the plan in the given format (text or json)
+func (p *Plan) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return p.WriteJSON(w)
+	case "text", "":
+		return p.WriteText(w)
+	default:
+		return errors.New(fmt.Sprintf("unknown report format %s", format))
+	}
+}
@@ -0,0 +1,116 @@
+package bagit
+
+import (
+	"archive/zip"
+	"github.com/je4/bagarc/v2/pkg/indexer"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// the plan must describe the bag, which is created by Run
+func TestPlan(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, map[string]string{
+		"Foo.txt":      "upper",
+		"foo.txt":      "lower",
+		"sub/a.txt":    strings.Repeat("compressible ", 100),
+		"sub/b.bin":    strings.Repeat("stored ", 100),
+		"sub/Bar.bin":  "stored",
+		"sub/bar.bin":  "stored too",
+		"debug.log":    "excluded",
+		"sub/deep.log": "excluded",
+	})
+	filter, err := NewFilter(nil, []string{"*.log"})
+	if err != nil {
+		t.Fatalf("cannot create filter: %v", err)
+	}
+	setup := func(bc *BagitCreator) {
+		bc.SetFilter(filter)
+		bc.SetCollisionResolution(sanitize.ResolveNumber, false)
+		if err := bc.SetCompressionRules([]CompressionRule{{Ext: []string{"bin"}, Method: CompressionStore}}); err != nil {
+			t.Fatalf("cannot set compression rules: %v", err)
+		}
+	}
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "no indexer in plan mode", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	bagfile := filepath.Join(t.TempDir(), "bag.zip")
+	bc := newTestCreator(t, sourcedir, bagfile)
+	setup(bc)
+	bc.SetIndexerClient(indexer.NewClient(server.URL, 1, testLogger))
+	plan, err := bc.Plan()
+	if err != nil {
+		t.Fatalf("cannot plan: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("%v requests to indexer in plan mode", n)
+	}
+
+	createTestBag(t, sourcedir, bagfile, setup)
+	validateTestBag(t, bagfile)
+
+	bagInfo, err := readBagFile(bagfile, "bag-info.txt")
+	if err != nil {
+		t.Fatalf("cannot read bag-info.txt: %v", err)
+	}
+	if !strings.Contains(string(bagInfo), "Payload-Oxum: "+plan.PayloadOxum+"\n") {
+		t.Errorf("Payload-Oxum %s of plan not in bag-info.txt:\n%s", plan.PayloadOxum, bagInfo)
+	}
+
+	zr, err := zip.OpenReader(bagfile)
+	if err != nil {
+		t.Fatalf("cannot open %s: %v", bagfile, err)
+	}
+	defer zr.Close()
+	renames, err := readRenames(zr)
+	if err != nil {
+		t.Fatalf("cannot read renames.csv: %v", err)
+	}
+	expected := []string{}
+	for zipPath, orig := range renames {
+		expected = append(expected, "/"+orig+" -> /"+zipPath)
+	}
+	stored := []string{}
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "data/") && f.Method == zip.Store {
+			stored = append(stored, strings.TrimPrefix(f.Name, "data"))
+		}
+	}
+
+	// names of the plan are relative to the source folder with a leading "/"
+	for _, compare := range []struct {
+		name     string
+		entries  []*PlanEntry
+		format   func(e *PlanEntry) string
+		expected []string
+	}{
+		{"renames", plan.Renames, func(e *PlanEntry) string { return e.Path + " -> " + e.ZipPath }, expected},
+		{"collisions", plan.Collisions, func(e *PlanEntry) string { return e.Path + " -> " + e.ZipPath }, expected},
+		{"uncompressed", plan.Uncompressed, func(e *PlanEntry) string { return e.ZipPath }, stored},
+		{"excluded", plan.Excluded, func(e *PlanEntry) string { return e.Path }, []string{"debug.log", "sub/deep.log"}},
+	} {
+		result := []string{}
+		for _, e := range compare.entries {
+			result = append(result, compare.format(e))
+		}
+		sort.Strings(result)
+		sort.Strings(compare.expected)
+		if strings.Join(result, ", ") != strings.Join(compare.expected, ", ") {
+			t.Errorf("%s of plan: %v, bag: %v", compare.name, result, compare.expected)
+		}
+	}
+	if len(plan.Renames) != 2 {
+		t.Errorf("%v renames, expected 2", len(plan.Renames))
+	}
+}
@@ -0,0 +1,164 @@
+package bagit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+type FindingType string
+
+const (
+	FindingMissingFile      FindingType = "missing-file"      // file listed in manifest but not in bag
+	FindingExtraFile        FindingType = "extra-file"        // payload file not listed in any manifest
+	FindingChecksumMismatch FindingType = "checksum-mismatch" // checksum of file differs from manifest
+	FindingPayloadOxum      FindingType = "payload-oxum"      // wrong or invalid Payload-Oxum
+	FindingTagManifest      FindingType = "tagmanifest"       // tag file verification failed
+	FindingManifest         FindingType = "manifest"          // invalid or missing manifest
+	FindingBagitTxt         FindingType = "bagit-txt"         // invalid bagit.txt
+	FindingReadError        FindingType = "read-error"        // file could not be read from bag
+	FindingFetch            FindingType = "fetch"             // invalid or missing fetch.txt item
+	FindingMetadata         FindingType = "metadata"          // file metadata could not be restored
+	FindingEncoding         FindingType = "encoding"          // tag file does not match declared encoding
+	FindingStructure        FindingType = "structure"         // link or empty directory could not be restored
+)
+
+// Finding is a single problem found during validation
+type Finding struct {
+	Severity Severity    `json:"severity"`
+	Type     FindingType `json:"type"`
+	File     string      `json:"file,omitempty"`
+	Message  string      `json:"message"`
+}
+
+func (f *Finding) String() string {
+	if f.File == "" {
+		return fmt.Sprintf("[%s] %s: %s", f.Severity, f.Type, f.Message)
+	}
+	return fmt.Sprintf("[%s] %s: %s - %s", f.Severity, f.Type, f.File, f.Message)
+}
+
+// Report collects all findings of a bagit validation
+type Report struct {
+	mu        sync.Mutex
+	Bag       string     `json:"bag"`
+	Version   string     `json:"version,omitempty"`
+	Encoding  string     `json:"encoding,omitempty"`
+	Checksums []string   `json:"checksums,omitempty"`
+	Files     int64      `json:"files"`
+	Findings  []*Finding `json:"findings"`
+}
+
+func NewReport(bag string) *Report {
+	return &Report{
+		Bag:       bag,
+		Checksums: []string{},
+		Findings:  []*Finding{},
+	}
+}
+
+func (r *Report) add(severity Severity, fType FindingType, file string, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Findings = append(r.Findings, &Finding{
+		Severity: severity,
+		Type:     fType,
+		File:     file,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Errorf adds a finding with error severity
+func (r *Report) Errorf(fType FindingType, file string, format string, args ...interface{}) {
+	r.add(SeverityError, fType, file, format, args...)
+}
+
+// Warningf adds a finding with warning severity
+func (r *Report) Warningf(fType FindingType, file string, format string, args ...interface{}) {
+	r.add(SeverityWarning, fType, file, format, args...)
+}
+
+func (r *Report) count(severity Severity) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var num int
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			num++
+		}
+	}
+	return num
+}
+
+func (r *Report) NumErrors() int   { return r.count(SeverityError) }
+func (r *Report) NumWarnings() int { return r.count(SeverityWarning) }
+func (r *Report) HasErrors() bool  { return r.NumErrors() > 0 }
+
+// Err returns nil, if there are no findings with error severity
+func (r *Report) Err() error {
+	num := r.NumErrors()
+	if num == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			if num == 1 {
+				return errors.New(f.String())
+			}
+			return errors.New(fmt.Sprintf("%s (and %v more errors)", f.String(), num-1))
+		}
+	}
+	return nil
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+func (r *Report) WriteText(w io.Writer) error {
+	errNum := r.NumErrors()
+	warnNum := r.NumWarnings()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "bag: %s\nversion: %s\nencoding: %s\nchecksums: %v\nfiles: %v\n",
+		r.Bag, r.Version, r.Encoding, r.Checksums, r.Files); err != nil {
+		return err
+	}
+	for _, f := range r.Findings {
+		if _, err := fmt.Fprintln(w, f.String()); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%v errors, %v warnings\n", errNum, warnNum); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Write writes the report in the given format (text or json)
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return r.WriteJSON(w)
+	case "text", "":
+		return r.WriteText(w)
+	default:
+		return errors.New(fmt.Sprintf("unknown report format %s", format))
+	}
+}
@@ -0,0 +1,581 @@
+package bagit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/goph/emperror"
+	"github.com/klauspost/compress/zstd"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// an interrupted bag creation is resumed by comparing the partial bag with the
+// files recorded in the database. the bag is cut after the last complete entry,
+// records of all entries behind are dropped and the walk continues with the missing files
+
+const (
+	zipLocalHeaderSignature    = 0x04034b50
+	zipDataDescriptorSignature = 0x08074b50
+	zipLocalHeaderLen          = 30
+	zipUint32Max               = (1 << 32) - 1
+	zipZip64ExtraID            = 0x0001
+	zstdFrameMagic             = 0xFD2FB528
+)
+
+// isRecorded checks, whether file is already part of the bag
+func (bc *BagitCreator) isRecorded(filePath string) (bool, error) {
+	var found bool
+	if err := bc.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(filePath))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return nil
+	}); err != nil {
+		return false, emperror.Wrapf(err, "cannot query database for %s", filePath)
+	}
+	return found, nil
+}
+
+// loadRecords reads all files from database. key is the name within the bag
+func (bc *BagitCreator) loadRecords() (map[string]*BagitFile, error) {
+	records := map[string]*BagitFile{}
+	if err := bc.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := it.Item().Value(func(v []byte) error {
+				bf := &BagitFile{}
+				if err := json.Unmarshal(v, bf); err != nil {
+					return emperror.Wrapf(err, "cannot unmarshal json: %v", string(v))
+				}
+				records[path.Join("data", bf.ZipPath)] = bf
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, emperror.Wrap(err, "cannot read database")
+	}
+	return records, nil
+}
+
+// keepRecords removes all records of files, which are not within the bag and
+// adds the others to Payload-Oxum
+func (bc *BagitCreator) keepRecords(records map[string]*BagitFile, kept map[string]bool) error {
+	for name, bf := range records {
+		// links and empty directories are only recorded
+		if bf.Type != "" {
+			continue
+		}
+		// external files are not within the bag
+		if kept[name] || bf.Fetch != "" {
+			bc.oxumOctetCount += bf.Size
+			bc.oxumStreamCount++
+			continue
+		}
+		bc.logger.Infof("%s incomplete - adding again", name)
+		if err := bc.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete([]byte(bf.Path))
+		}); err != nil {
+			return emperror.Wrapf(err, "cannot remove %s from database", bf.Path)
+		}
+	}
+	bc.logger.Infof("resuming with %v of %v files", bc.oxumStreamCount, len(records))
+	return nil
+}
+
+// verifyRecord compares content with a checksum of the record
+func verifyRecord(r io.Reader, bf *BagitFile) (bool, error) {
+	checksums := []string{}
+	for cs := range bf.Checksum {
+		checksums = append(checksums, cs)
+	}
+	if len(checksums) == 0 {
+		return false, nil
+	}
+	sort.Strings(checksums)
+	cSums, err := ChecksumCopy(&NullWriter{}, r, checksums[:1])
+	if err != nil {
+		return false, err
+	}
+	return cSums[checksums[0]] == bf.Checksum[checksums[0]], nil
+}
+
+// resumeBag opens the partial bag for appending. it returns true, if bagit.txt is already within the bag
+func (bc *BagitCreator) resumeBag() (BagWriter, bool, error) {
+	// a compressed stream cannot be continued
+	if format := BagFormat(bc.bagitfile); format == FormatTarGz || format == FormatTarZst {
+		return nil, false, errors.New(fmt.Sprintf("cannot resume %s bag %s: only uncompressed tar bags can be resumed", format, bc.bagitfile))
+	}
+	records, err := bc.loadRecords()
+	if err != nil {
+		return nil, false, err
+	}
+	var bagWriter BagWriter
+	var hasBagitTxt bool
+	var kept map[string]bool
+	switch format := BagFormat(bc.bagitfile); format {
+	case FormatZip:
+		bagWriter, hasBagitTxt, kept, err = bc.resumeZip(records)
+	case FormatTar:
+		bagWriter, hasBagitTxt, kept, err = bc.resumeTar(records)
+	case FormatFolder:
+		bagWriter, kept, err = bc.resumeFolder(records)
+	default:
+		return nil, false, errors.New(fmt.Sprintf("cannot resume %s bag %s", format, bc.bagitfile))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := bc.keepRecords(records, kept); err != nil {
+		bagWriter.Close()
+		return nil, false, err
+	}
+	return bagWriter, hasBagitTxt, nil
+}
+
+// resumeEntry is a complete entry of a partial zip
+type resumeEntry struct {
+	header zip.FileHeader
+	offset int64 // start of compressed data
+}
+
+// countingByteReader counts the bytes consumed by the decompressor.
+// flate reads no more than necessary from an io.ByteReader
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cbr *countingByteReader) Read(p []byte) (int, error) {
+	n, err := cbr.r.Read(p)
+	cbr.n += int64(n)
+	return n, err
+}
+
+func (cbr *countingByteReader) ReadByte() (byte, error) {
+	b, err := cbr.r.ReadByte()
+	if err == nil {
+		cbr.n++
+	}
+	return b, err
+}
+
+// measureZipData reads the content of an entry and returns compressed size, uncompressed size and crc.
+// the size of stored entries without data descriptor cannot be detected and has to be given
+func measureZipData(r io.ReaderAt, offset, size int64, method uint16, storedSize int64) (int64, int64, uint32, error) {
+	crc := crc32.NewIEEE()
+	switch method {
+	case zip.Store:
+		if storedSize < 0 || offset+storedSize > size {
+			return 0, 0, 0, io.ErrUnexpectedEOF
+		}
+		if _, err := io.Copy(crc, io.NewSectionReader(r, offset, storedSize)); err != nil {
+			return 0, 0, 0, err
+		}
+		return storedSize, storedSize, crc.Sum32(), nil
+	case zip.Deflate:
+		cbr := &countingByteReader{r: bufio.NewReader(io.NewSectionReader(r, offset, size-offset))}
+		fr := flate.NewReader(cbr)
+		defer fr.Close()
+		uncompressed, err := io.Copy(crc, fr)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return cbr.n, uncompressed, crc.Sum32(), nil
+	case ZipZstd:
+		// the decoder reads ahead, so the size is taken from the frame
+		compressed, err := zstdFrameSize(io.NewSectionReader(r, offset, size-offset))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if offset+compressed > size {
+			return 0, 0, 0, io.ErrUnexpectedEOF
+		}
+		zr := zstd.ZipDecompressor()(io.NewSectionReader(r, offset, compressed))
+		defer zr.Close()
+		uncompressed, err := io.Copy(crc, zr)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return compressed, uncompressed, crc.Sum32(), nil
+	default:
+		return 0, 0, 0, zip.ErrAlgorithm
+	}
+}
+
+// zstdFrameSize returns the size of the zstd frame at the beginning of r by walking
+// through the block headers (RFC 8878). empty files are written without frame
+func zstdFrameSize(r io.ReaderAt) (int64, error) {
+	header := make([]byte, 5)
+	if _, err := r.ReadAt(header, 0); err != nil || binary.LittleEndian.Uint32(header) != zstdFrameMagic {
+		return 0, nil
+	}
+	descriptor := header[4]
+	singleSegment := descriptor&0x20 != 0
+	pos := int64(len(header))
+	if !singleSegment {
+		pos++ // window descriptor
+	}
+	pos += []int64{0, 1, 2, 4}[descriptor&0x3] // dictionary id
+	switch fcs := descriptor >> 6; {
+	case fcs == 0 && singleSegment:
+		pos++
+	case fcs > 0:
+		pos += 1 << fcs // frame content size of 2, 4 or 8 bytes
+	}
+	block := make([]byte, 3)
+	for {
+		if _, err := r.ReadAt(block, pos); err != nil {
+			return 0, err
+		}
+		blockHeader := uint32(block[0]) | uint32(block[1])<<8 | uint32(block[2])<<16
+		pos += int64(len(block))
+		switch blockType := (blockHeader >> 1) & 0x3; blockType {
+		case 1: // rle
+			pos++
+		case 3:
+			return 0, errors.New("invalid zstd block type")
+		default:
+			pos += int64(blockHeader >> 3)
+		}
+		if blockHeader&0x1 != 0 {
+			break
+		}
+	}
+	if descriptor&0x4 != 0 {
+		pos += 4 // content checksum
+	}
+	return pos, nil
+}
+
+// stripZip64Extra removes zip64 sizes from extra field, they are recreated by the writer.
+// the sizes (uncompressed, compressed) of the local header are returned
+func stripZip64Extra(extra []byte) ([]byte, []int64) {
+	result := []byte{}
+	sizes := []int64{}
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		l := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+l > len(extra) {
+			break
+		}
+		if id != zipZip64ExtraID {
+			result = append(result, extra[:4+l]...)
+		} else {
+			for i := 4; i+8 <= 4+l; i += 8 {
+				sizes = append(sizes, int64(binary.LittleEndian.Uint64(extra[i:])))
+			}
+		}
+		extra = extra[4+l:]
+	}
+	return result, sizes
+}
+
+// scanZip reads the local headers of a zip without central directory and returns
+// all complete entries up to the first missing, damaged or unknown one
+func scanZip(r io.ReaderAt, size int64, records map[string]*BagitFile) []*resumeEntry {
+	entries := []*resumeEntry{}
+	var pos int64
+	for {
+		buf := make([]byte, zipLocalHeaderLen)
+		if _, err := r.ReadAt(buf, pos); err != nil {
+			break
+		}
+		if binary.LittleEndian.Uint32(buf[0:]) != zipLocalHeaderSignature {
+			break
+		}
+		readerVersion := binary.LittleEndian.Uint16(buf[4:])
+		flags := binary.LittleEndian.Uint16(buf[6:])
+		method := binary.LittleEndian.Uint16(buf[8:])
+		modTime := binary.LittleEndian.Uint16(buf[10:])
+		modDate := binary.LittleEndian.Uint16(buf[12:])
+		headerCRC := binary.LittleEndian.Uint32(buf[14:])
+		headerCompSize := int64(binary.LittleEndian.Uint32(buf[18:]))
+		headerSize := int64(binary.LittleEndian.Uint32(buf[22:]))
+		nameLen := int(binary.LittleEndian.Uint16(buf[26:]))
+		extraLen := int(binary.LittleEndian.Uint16(buf[28:]))
+		nameExtra := make([]byte, nameLen+extraLen)
+		if _, err := r.ReadAt(nameExtra, pos+zipLocalHeaderLen); err != nil {
+			break
+		}
+		name := string(nameExtra[:nameLen])
+		extra, zip64Sizes := stripZip64Extra(nameExtra[nameLen:])
+		if headerSize == zipUint32Max && headerCompSize == zipUint32Max && len(zip64Sizes) >= 2 {
+			headerSize, headerCompSize = zip64Sizes[0], zip64Sizes[1]
+		}
+		offset := pos + zipLocalHeaderLen + int64(nameLen+extraLen)
+
+		// only bagit.txt and recorded payload files are kept
+		storedSize := int64(-1)
+		if name != "bagit.txt" {
+			bf, ok := records[name]
+			if !ok {
+				break
+			}
+			storedSize = bf.Size
+		}
+		hasDescriptor := flags&0x8 != 0
+		if !hasDescriptor {
+			storedSize = headerCompSize
+		}
+		compSize, uncompSize, crc, err := measureZipData(r, offset, size, method, storedSize)
+		if err != nil {
+			break
+		}
+		if name != "bagit.txt" && uncompSize != records[name].Size {
+			break
+		}
+		next := offset + compSize
+		if hasDescriptor {
+			desc := make([]byte, 24)
+			n, _ := r.ReadAt(desc, next)
+			desc = desc[:n]
+			if len(desc) >= 4 && binary.LittleEndian.Uint32(desc) == zipDataDescriptorSignature {
+				desc = desc[4:]
+				next += 4
+			}
+			var descCRC uint32
+			var descCompSize, descSize int64
+			if compSize >= zipUint32Max || uncompSize >= zipUint32Max {
+				if len(desc) < 20 {
+					break
+				}
+				descCRC = binary.LittleEndian.Uint32(desc)
+				descCompSize = int64(binary.LittleEndian.Uint64(desc[4:]))
+				descSize = int64(binary.LittleEndian.Uint64(desc[12:]))
+				next += 20
+			} else {
+				if len(desc) < 12 {
+					break
+				}
+				descCRC = binary.LittleEndian.Uint32(desc)
+				descCompSize = int64(binary.LittleEndian.Uint32(desc[4:]))
+				descSize = int64(binary.LittleEndian.Uint32(desc[8:]))
+				next += 12
+			}
+			if descCRC != crc || descCompSize != compSize || descSize != uncompSize {
+				break
+			}
+		} else if headerCRC != crc || headerSize != uncompSize {
+			break
+		}
+		entries = append(entries, &resumeEntry{
+			header: zip.FileHeader{
+				Name:               name,
+				ReaderVersion:      readerVersion,
+				Flags:              flags &^ 0x8,
+				Method:             method,
+				ModifiedTime:       modTime,
+				ModifiedDate:       modDate,
+				CRC32:              crc,
+				CompressedSize64:   uint64(compSize),
+				UncompressedSize64: uint64(uncompSize),
+				Extra:              extra,
+			},
+			offset: offset,
+		})
+		pos = next
+	}
+	return entries
+}
+
+// resumeZip copies all complete entries of the partial zip to a new zip.
+// the partial zip is kept as <bagit>.partial until the copy is done
+func (bc *BagitCreator) resumeZip(records map[string]*BagitFile) (BagWriter, bool, map[string]bool, error) {
+	kept := map[string]bool{}
+	partial := bc.bagitfile + ".partial"
+	_, errBag := os.Stat(bc.bagitfile)
+	_, errPartial := os.Stat(partial)
+	switch {
+	case errBag == nil && errPartial == nil:
+		// a previous resume was interrupted while copying, the partial zip is the only complete copy
+		bc.logger.Infof("removing incomplete copy %s", bc.bagitfile)
+		if err := os.Remove(bc.bagitfile); err != nil {
+			return nil, false, nil, emperror.Wrapf(err, "cannot remove %s", bc.bagitfile)
+		}
+	case errBag == nil:
+		// a previous resume has already copied the entries
+		if err := os.Rename(bc.bagitfile, partial); err != nil {
+			return nil, false, nil, emperror.Wrapf(err, "cannot rename %s", bc.bagitfile)
+		}
+	case errPartial != nil:
+		// nothing to resume
+		bagWriter, err := NewBagWriter(bc.bagitfile)
+		return bagWriter, false, kept, err
+	}
+
+	src, err := os.Open(partial)
+	if err != nil {
+		return nil, false, nil, emperror.Wrapf(err, "cannot open %s", partial)
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return nil, false, nil, emperror.Wrapf(err, "cannot stat %s", partial)
+	}
+	entries := scanZip(src, stat.Size(), records)
+
+	fp, err := os.Create(bc.bagitfile)
+	if err != nil {
+		return nil, false, nil, emperror.Wrapf(err, "cannot create zip file %v", bc.bagitfile)
+	}
+	zbw := NewZipBagWriter(fp)
+	var hasBagitTxt bool
+	for _, entry := range entries {
+		header := entry.header
+		w, err := zbw.w.CreateRaw(&header)
+		if err != nil {
+			zbw.Close()
+			return nil, false, nil, emperror.Wrapf(err, "cannot create %s", header.Name)
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(src, entry.offset, int64(header.CompressedSize64))); err != nil {
+			zbw.Close()
+			return nil, false, nil, emperror.Wrapf(err, "cannot copy %s", header.Name)
+		}
+		if header.Name == "bagit.txt" {
+			hasBagitTxt = true
+		} else {
+			kept[header.Name] = true
+		}
+	}
+	if err := zbw.w.Flush(); err != nil {
+		zbw.Close()
+		return nil, false, nil, emperror.Wrapf(err, "cannot write %s", bc.bagitfile)
+	}
+	bc.logger.Infof("%v entries of %s copied", len(entries), partial)
+	src.Close()
+	if err := os.Remove(partial); err != nil {
+		bc.logger.Errorf("cannot remove %s: %v", partial, err)
+	}
+	return zbw, hasBagitTxt, kept, nil
+}
+
+// resumeTar cuts the tar after the last complete entry and appends to it
+func (bc *BagitCreator) resumeTar(records map[string]*BagitFile) (BagWriter, bool, map[string]bool, error) {
+	kept := map[string]bool{}
+	fp, err := os.OpenFile(bc.bagitfile, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			bagWriter, err := NewBagWriter(bc.bagitfile)
+			return bagWriter, false, kept, err
+		}
+		return nil, false, nil, emperror.Wrapf(err, "cannot open %s", bc.bagitfile)
+	}
+	stat, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, false, nil, emperror.Wrapf(err, "cannot stat %s", bc.bagitfile)
+	}
+	size := stat.Size()
+	sr := io.NewSectionReader(fp, 0, size)
+	tr := tar.NewReader(sr)
+	var end int64
+	var hasBagitTxt bool
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		offset, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil || offset+header.Size > size {
+			break
+		}
+		name := path.Clean(header.Name)
+		if name == "bagit.txt" {
+			hasBagitTxt = true
+		} else {
+			bf, ok := records[name]
+			if !ok || bf.Size != header.Size {
+				break
+			}
+			ok, err := verifyRecord(io.NewSectionReader(fp, offset, header.Size), bf)
+			if err != nil || !ok {
+				break
+			}
+			kept[name] = true
+		}
+		// content is padded to whole blocks
+		end = offset + (header.Size+511)/512*512
+	}
+	if err := fp.Truncate(end); err != nil {
+		fp.Close()
+		return nil, false, nil, emperror.Wrapf(err, "cannot truncate %s", bc.bagitfile)
+	}
+	if _, err := fp.Seek(end, io.SeekStart); err != nil {
+		fp.Close()
+		return nil, false, nil, emperror.Wrapf(err, "cannot seek %s", bc.bagitfile)
+	}
+	tbw, err := NewTarBagWriter(fp, TarPlain)
+	if err != nil {
+		fp.Close()
+		return nil, false, nil, err
+	}
+	return tbw, hasBagitTxt, kept, nil
+}
+
+// resumeFolder keeps all complete payload files and removes the others.
+// tag files are written again
+func (bc *BagitCreator) resumeFolder(records map[string]*BagitFile) (BagWriter, map[string]bool, error) {
+	kept := map[string]bool{}
+	if _, err := os.Stat(bc.bagitfile); err != nil {
+		bagWriter, err := NewBagWriter(bc.bagitfile)
+		return bagWriter, kept, err
+	}
+	dataFolder := filepath.Join(bc.bagitfile, "data")
+	if err := filepath.WalkDir(dataFolder, func(fullpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(bc.bagitfile, fullpath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if bf, ok := records[name]; ok {
+			fp, err := os.Open(fullpath)
+			if err != nil {
+				return err
+			}
+			ok, err := verifyRecord(fp, bf)
+			fp.Close()
+			if err != nil {
+				return emperror.Wrapf(err, "cannot verify %s", fullpath)
+			}
+			if ok {
+				kept[name] = true
+				return nil
+			}
+		}
+		bc.logger.Infof("removing incomplete %s", fullpath)
+		return os.Remove(fullpath)
+	}); err != nil {
+		return nil, nil, emperror.Wrapf(err, "cannot check %s", dataFolder)
+	}
+	return &DirBagWriter{folder: bc.bagitfile}, kept, nil
+}
@@ -0,0 +1,173 @@
+package bagit
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// an interrupted zip bag is resumed after the last complete entry
+func TestResumeZip(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeGeneratedTree(t, sourcedir, 20)
+	// zstd writes no frame for empty files and several blocks for large ones
+	large := make([]byte, 512*1024)
+	rand.New(rand.NewSource(1)).Read(large)
+	writeTestTree(t, sourcedir, map[string]string{"empty.txt": "", "a-large.txt": hex.EncodeToString(large)})
+
+	for _, test := range []struct {
+		name   string
+		rules  []CompressionRule
+		method uint16
+	}{
+		{name: "deflate", method: zip.Deflate},
+		{name: "store", rules: []CompressionRule{{Ext: []string{"txt"}, Method: CompressionStore}}, method: zip.Store},
+		{name: "zstd", rules: []CompressionRule{{Ext: []string{"txt"}, Method: CompressionZstd}}, method: ZipZstd},
+		{name: "zstd 19", rules: []CompressionRule{{Ext: []string{"txt"}, Method: CompressionZstd, Level: 19}}, method: ZipZstd},
+	} {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s workers=%v", test.name, workers), func(t *testing.T) {
+				tempdir := t.TempDir()
+				db := openTestDB(t, tempdir)
+				defer db.Close()
+				bagfile := filepath.Join(t.TempDir(), "bag.zip")
+				newCreator := func() *BagitCreator {
+					bc := newTestCreatorDB(t, sourcedir, bagfile, tempdir, db)
+					bc.SetWorkers(workers)
+					if err := bc.SetCompressionRules(test.rules); err != nil {
+						t.Fatalf("cannot set compression rules: %v", err)
+					}
+					return bc
+				}
+				if err := newCreator().Run(); err != nil {
+					t.Fatalf("cannot create bag: %v", err)
+				}
+
+				// cut the bag within the compressed data of the 10th payload file (bagit.txt is the first entry)
+				zr, err := zip.OpenReader(bagfile)
+				if err != nil {
+					t.Fatalf("cannot open %s: %v", bagfile, err)
+				}
+				var cut int64
+				var complete int
+				for i, f := range zr.File {
+					if strings.HasPrefix(f.Name, "data/") && f.Method != test.method {
+						t.Errorf("method of %s is %v, expected %v", f.Name, f.Method, test.method)
+					}
+					if i == 10 {
+						offset, err := f.DataOffset()
+						if err != nil {
+							t.Fatalf("cannot get offset of %s: %v", f.Name, err)
+						}
+						cut = offset + int64(f.CompressedSize64)/2
+						complete = i
+						break
+					}
+				}
+				zr.Close()
+				if err := os.Truncate(bagfile, cut); err != nil {
+					t.Fatalf("cannot truncate %s: %v", bagfile, err)
+				}
+
+				bc := newCreator()
+				records, err := bc.loadRecords()
+				if err != nil {
+					t.Fatalf("cannot load records: %v", err)
+				}
+				fp, err := os.Open(bagfile)
+				if err != nil {
+					t.Fatalf("cannot open %s: %v", bagfile, err)
+				}
+				entries := scanZip(fp, cut, records)
+				fp.Close()
+				if len(entries) != complete {
+					t.Errorf("%v complete entries found, expected %v", len(entries), complete)
+				}
+
+				bc.SetResume(true)
+				if err := bc.Run(); err != nil {
+					t.Fatalf("cannot resume bag: %v", err)
+				}
+				validateTestBag(t, bagfile)
+				headers, _, content := readZipRaw(t, bagfile)
+				if len(headers) != 22 {
+					t.Errorf("%v payload files after resume, expected 22", len(headers))
+				}
+				for _, header := range headers {
+					data, err := os.ReadFile(filepath.Join(sourcedir, filepath.FromSlash(strings.TrimPrefix(header.Name, "data/"))))
+					if err != nil {
+						t.Fatalf("cannot read source of %s: %v", header.Name, err)
+					}
+					if string(data) != string(content[header.Name]) {
+						t.Errorf("content of %s differs from source", header.Name)
+					}
+				}
+			})
+		}
+	}
+}
+
+// a resume, which is interrupted while copying the partial zip, is resumed again
+func TestResumeZipInterrupted(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeGeneratedTree(t, sourcedir, 20)
+	tempdir := t.TempDir()
+	db := openTestDB(t, tempdir)
+	defer db.Close()
+	bagfile := filepath.Join(t.TempDir(), "bag.zip")
+	if err := newTestCreatorDB(t, sourcedir, bagfile, tempdir, db).Run(); err != nil {
+		t.Fatalf("cannot create bag: %v", err)
+	}
+
+	// cut the bag after the 10th payload file
+	zr, err := zip.OpenReader(bagfile)
+	if err != nil {
+		t.Fatalf("cannot open %s: %v", bagfile, err)
+	}
+	offset, err := zr.File[11].DataOffset()
+	zr.Close()
+	if err != nil {
+		t.Fatalf("cannot get offset: %v", err)
+	}
+	if err := os.Truncate(bagfile, offset); err != nil {
+		t.Fatalf("cannot truncate %s: %v", bagfile, err)
+	}
+	// the interrupted resume has renamed the bag and copied only a few bytes
+	partial := bagfile + ".partial"
+	if err := os.Rename(bagfile, partial); err != nil {
+		t.Fatalf("cannot rename %s: %v", bagfile, err)
+	}
+	if err := os.WriteFile(bagfile, []byte("PK\x03\x04"), 0644); err != nil {
+		t.Fatalf("cannot write %s: %v", bagfile, err)
+	}
+
+	bc := newTestCreatorDB(t, sourcedir, bagfile, tempdir, db)
+	bagWriter, hasBagitTxt, err := bc.resumeBag()
+	if err != nil {
+		t.Fatalf("cannot resume: %v", err)
+	}
+	if err := bagWriter.Close(); err != nil {
+		t.Fatalf("cannot close %s: %v", bagfile, err)
+	}
+	if !hasBagitTxt || bc.oxumStreamCount != 10 {
+		t.Errorf("bagit.txt %v and %v payload files kept, expected 10", hasBagitTxt, bc.oxumStreamCount)
+	}
+	if _, err := os.Stat(partial); err == nil {
+		t.Errorf("%s not removed", partial)
+	}
+
+	bc = newTestCreatorDB(t, sourcedir, bagfile, tempdir, db)
+	bc.SetResume(true)
+	if err := bc.Run(); err != nil {
+		t.Fatalf("cannot resume bag: %v", err)
+	}
+	validateTestBag(t, bagfile)
+	if headers, _, _ := readZipRaw(t, bagfile); len(headers) != 20 {
+		t.Errorf("%v payload files after resume, expected 20", len(headers))
+	}
+}
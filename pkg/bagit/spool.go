@@ -0,0 +1,174 @@
+package bagit
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"github.com/goph/emperror"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// files of zip bags are compressed by the workers into a spool and appended to the zip
+// without recompression (like zipfs.FS.Close). small files are spooled in memory,
+// larger ones in the temp folder
+
+// level of the deflate compressor of archive/zip. same level, same bytes
+const zipDeflateLevel = 5
+
+// larger files are compressed by the writer to limit the size of the temp folder (variable for tests)
+var maxSpoolSize int64 = 1024 * 1024 * 1024
+
+// spoolFile is a compressed file with everything needed for the zip header
+type spoolFile struct {
+	method         uint16
+	crc32          uint32
+	size           int64
+	compressedSize int64
+	data           []byte // small files
+	tempfile       string // large files
+}
+
+// countWriter counts the written bytes
+type countWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
+
+// spool compresses the file into memory or a file in tempdir and calculates the checksums
+func (bf *BagitFile) spool(checksum []string, tempdir string, compression *Compression) (sf *spoolFile, err error) {
+	fullpath := filepath.Join(bf.baseDir, bf.Path)
+	src, err := os.Open(fullpath)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot open %v", fullpath)
+	}
+	defer src.Close()
+
+	sf = &spoolFile{method: compression.zipMethod()}
+	var dst io.Writer
+	buf := &bytes.Buffer{}
+	if bf.Size <= prereadSize {
+		dst = buf
+	} else {
+		tmp, err2 := os.CreateTemp(tempdir, "spool-*")
+		if err2 != nil {
+			return nil, emperror.Wrapf(err2, "cannot create spool file in %s", tempdir)
+		}
+		sf.tempfile = tmp.Name()
+		defer func() {
+			if err2 := tmp.Close(); err2 != nil && err == nil {
+				err = emperror.Wrapf(err2, "cannot close %s", sf.tempfile)
+			}
+			if err != nil {
+				os.Remove(sf.tempfile)
+			}
+		}()
+		dst = tmp
+	}
+	compressed := &countWriter{w: dst}
+	cw, err := newCompressor(compressed, sf.method, compression.Level)
+	if err != nil {
+		return nil, err
+	}
+	uncompressed := &countWriter{w: cw}
+	crc := crc32.NewIEEE()
+	if bf.Checksum, err = ChecksumCopy(io.MultiWriter(uncompressed, crc), src, checksum); err != nil {
+		cw.Close()
+		return nil, emperror.Wrapf(err, "cannot compress %v", fullpath)
+	}
+	if err := cw.Close(); err != nil {
+		return nil, emperror.Wrapf(err, "cannot compress %v", fullpath)
+	}
+	sf.crc32 = crc.Sum32()
+	sf.size = uncompressed.count
+	sf.compressedSize = compressed.count
+	if sf.tempfile == "" {
+		sf.data = buf.Bytes()
+	}
+	return sf, nil
+}
+
+// remove deletes the spool file
+func (sf *spoolFile) remove() {
+	if sf.tempfile != "" {
+		os.Remove(sf.tempfile)
+	}
+}
+
+// detectUTF8 is the test of zip.Writer for names, which need the UTF-8 flag
+func detectUTF8(s string) (valid, require bool) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r < 0x20 || r > 0x7d || r == 0x5c {
+			if !utf8.ValidRune(r) || (r == utf8.RuneError && size == 1) {
+				return false, false
+			}
+			require = true
+		}
+	}
+	return true, require
+}
+
+// writeSpool appends a spooled file. the header is the same as the one of
+// zip.Writer.CreateHeader, so that the zip is byte identical to a sequential one
+func (zbw *ZipBagWriter) writeSpool(name string, info fs.FileInfo, sf *spoolFile) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return emperror.Wrap(err, "cannot create zip.FileInfoHeader")
+	}
+	header.Name = filepath.ToSlash(name)
+	header.Method = sf.method
+	if valid, require := detectUTF8(header.Name); valid && require {
+		header.Flags |= 0x800
+	}
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 20
+	header.ReaderVersion = 20
+	if !header.Modified.IsZero() {
+		// extended timestamp
+		extra := make([]byte, 9)
+		binary.LittleEndian.PutUint16(extra[0:], 0x5455)
+		binary.LittleEndian.PutUint16(extra[2:], 5)
+		extra[4] = 1
+		binary.LittleEndian.PutUint32(extra[5:], uint32(header.Modified.Unix()))
+		header.Extra = append(header.Extra, extra...)
+	}
+	header.Flags |= 0x8 // data descriptor
+	header.CRC32 = sf.crc32
+	header.UncompressedSize64 = uint64(sf.size)
+	header.CompressedSize64 = uint64(sf.compressedSize)
+	if header.UncompressedSize64 > math.MaxUint32 || header.CompressedSize64 > math.MaxUint32 {
+		header.ReaderVersion = 45 // zip64
+	}
+
+	w, err := zbw.w.CreateRaw(header)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot write header of %s to zip", name)
+	}
+	if sf.tempfile == "" {
+		if _, err := w.Write(sf.data); err != nil {
+			return emperror.Wrapf(err, "cannot write %s to zip", name)
+		}
+		return nil
+	}
+	fp, err := os.Open(sf.tempfile)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot open %s", sf.tempfile)
+	}
+	defer fp.Close()
+	if _, err := io.Copy(w, fp); err != nil {
+		return emperror.Wrapf(err, "cannot write %s to zip", name)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package bagit
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readZipRaw returns the headers of the central directory and the compressed data of all payload files
+func readZipRaw(t *testing.T, zipfile string) ([]zip.FileHeader, map[string][]byte, map[string][]byte) {
+	t.Helper()
+	zr, err := zip.OpenReader(zipfile)
+	if err != nil {
+		t.Fatalf("cannot open %s: %v", zipfile, err)
+	}
+	defer zr.Close()
+	headers := []zip.FileHeader{}
+	raw := map[string][]byte{}
+	content := map[string][]byte{}
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "data/") {
+			continue
+		}
+		headers = append(headers, f.FileHeader)
+		for _, open := range []func() (io.Reader, error){
+			f.OpenRaw,
+			func() (io.Reader, error) { return f.Open() },
+		} {
+			r, err := open()
+			if err != nil {
+				t.Fatalf("cannot open %s in %s: %v", f.Name, zipfile, err)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("cannot read %s in %s: %v", f.Name, zipfile, err)
+			}
+			if c, ok := r.(io.Closer); ok {
+				if err := c.Close(); err != nil {
+					t.Fatalf("cannot read %s in %s: %v", f.Name, zipfile, err)
+				}
+			}
+			if _, ok := raw[f.Name]; !ok {
+				raw[f.Name] = data
+			} else {
+				content[f.Name] = data
+			}
+		}
+	}
+	return headers, raw, content
+}
+
+// spooled entries appended with CreateRaw must be identical to entries written by zip.Writer
+func TestSpoolCompatible(t *testing.T) {
+	random := make([]byte, 3*prereadSize/2)
+	rand.New(rand.NewSource(1)).Read(random)
+	files := map[string]string{
+		"empty.txt":         "",
+		"small.txt":         "a small text file\n",
+		"umlaut äöü.txt":    strings.Repeat("text with umlauts äöü\n", 1000),
+		"sub/medium.txt":    strings.Repeat("medium sized text file\n", 20000),
+		"sub/large.txt":     strings.Repeat("large text file, which is spooled to a temporary file\n", 2*prereadSize/50),
+		"sub/random.bin":    string(random),
+		"sub/sub/small.bin": string(random[:1000]),
+	}
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, files)
+
+	for _, test := range []struct {
+		name         string
+		rules        []CompressionRule
+		maxSpoolSize int64
+	}{
+		{name: "deflate"},
+		{name: "deflate 9", rules: []CompressionRule{{Ext: []string{"txt", "bin"}, Method: CompressionDeflate, Level: 9}}},
+		{name: "zstd", rules: []CompressionRule{{Ext: []string{"txt", "bin"}, Method: CompressionZstd, Level: 19}}},
+		{name: "mixed", rules: []CompressionRule{{Ext: []string{"bin"}, Method: CompressionStore}, {Ext: []string{"txt"}, Method: CompressionZstd}}},
+		// larger files are not spooled, but read ahead or compressed by the writer
+		{name: "deflate beyond spool size", maxSpoolSize: 64 * 1024},
+		{name: "zstd beyond spool size", rules: []CompressionRule{{Ext: []string{"txt", "bin"}, Method: CompressionZstd}}, maxSpoolSize: 64 * 1024},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.maxSpoolSize > 0 {
+				defer func(size int64) { maxSpoolSize = size }(maxSpoolSize)
+				maxSpoolSize = test.maxSpoolSize
+			}
+			bagfiles := []string{}
+			for _, workers := range []int{1, 4} {
+				bagfile := filepath.Join(t.TempDir(), "bag.zip")
+				createTestBag(t, sourcedir, bagfile, func(bc *BagitCreator) {
+					bc.SetWorkers(workers)
+					if err := bc.SetCompressionRules(test.rules); err != nil {
+						t.Fatalf("cannot set compression rules: %v", err)
+					}
+				})
+				bagfiles = append(bagfiles, bagfile)
+			}
+			expectedHeaders, expectedRaw, expectedContent := readZipRaw(t, bagfiles[0])
+			headers, raw, content := readZipRaw(t, bagfiles[1])
+			if len(expectedHeaders) != len(files) {
+				t.Fatalf("%v payload files, expected %v", len(expectedHeaders), len(files))
+			}
+			if !reflect.DeepEqual(expectedHeaders, headers) {
+				for i := range expectedHeaders {
+					if i >= len(headers) || !reflect.DeepEqual(expectedHeaders[i], headers[i]) {
+						t.Fatalf("header %s differs:\n%s\nexpected\n%s", expectedHeaders[i].Name, headerString(headers, i), headerString(expectedHeaders, i))
+					}
+				}
+				t.Fatalf("%v headers, expected %v", len(headers), len(expectedHeaders))
+			}
+			for name, data := range expectedRaw {
+				if !bytes.Equal(data, raw[name]) {
+					t.Errorf("compressed data of %s differs", name)
+				}
+			}
+			for name, data := range files {
+				for i, c := range []map[string][]byte{expectedContent, content} {
+					if !bytes.Equal([]byte(data), c["data/"+name]) {
+						t.Errorf("content of %s differs from source in bag #%v", name, i+1)
+					}
+				}
+			}
+		})
+	}
+}
+
+func headerString(headers []zip.FileHeader, i int) string {
+	if i >= len(headers) {
+		return "no header"
+	}
+	h := headers[i]
+	return fmt.Sprintf("%s method %v flags %x crc %x size %v/%v version %v/%v extra %x attrs %x modified %v",
+		h.Name, h.Method, h.Flags, h.CRC32, h.CompressedSize64, h.UncompressedSize64, h.CreatorVersion, h.ReaderVersion, h.Extra, h.ExternalAttrs, h.Modified)
+}
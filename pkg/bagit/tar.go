@@ -0,0 +1,363 @@
+package bagit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/goph/emperror"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tar stream compressions
+const (
+	TarPlain = ""
+	TarGzip  = "gzip"
+	TarZstd  = "zstd"
+)
+
+// TarBagWriter writes a bag as (compressed) tar file.
+// compression applies to the whole stream, so the per file compression hint is ignored
+type TarBagWriter struct {
+	w          *tar.Writer
+	compressor io.WriteCloser
+	closer     io.Closer
+}
+
+// NewTarBagWriter creates a tar writer with stream compression (TarPlain, TarGzip or TarZstd).
+// if dst is an io.Closer, it's closed with the writer
+func NewTarBagWriter(dst io.Writer, compression string) (*TarBagWriter, error) {
+	tbw := &TarBagWriter{}
+	if closer, ok := dst.(io.Closer); ok {
+		tbw.closer = closer
+	}
+	var w io.Writer = dst
+	switch compression {
+	case TarPlain:
+	case TarGzip:
+		tbw.compressor = gzip.NewWriter(dst)
+		w = tbw.compressor
+	case TarZstd:
+		enc, err := zstd.NewWriter(dst)
+		if err != nil {
+			return nil, emperror.Wrap(err, "cannot create zstd encoder")
+		}
+		tbw.compressor = enc
+		w = enc
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown tar compression %s", compression))
+	}
+	tbw.w = tar.NewWriter(w)
+	return tbw, nil
+}
+
+// tarEntry writes file content directly to the tar stream. the size is known from the header
+type tarEntry struct {
+	w    *tar.Writer
+	name string
+}
+
+func (te *tarEntry) Write(p []byte) (int, error) {
+	return te.w.Write(p)
+}
+
+func (te *tarEntry) Close() error {
+	// fails, if less bytes than announced in header were written
+	if err := te.w.Flush(); err != nil {
+		return emperror.Wrapf(err, "cannot finish %s in tar", te.name)
+	}
+	return nil
+}
+
+// tarBufferEntry collects content of unknown size, which is written to the tar stream on close
+type tarBufferEntry struct {
+	bytes.Buffer
+	w       *tar.Writer
+	name    string
+	modTime time.Time
+}
+
+func (tbe *tarBufferEntry) Close() error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     tbe.name,
+		Size:     int64(tbe.Len()),
+		Mode:     0644,
+		ModTime:  tbe.modTime,
+	}
+	if err := tbe.w.WriteHeader(header); err != nil {
+		return emperror.Wrapf(err, "cannot write header of %s to tar", tbe.name)
+	}
+	if _, err := tbe.w.Write(tbe.Bytes()); err != nil {
+		return emperror.Wrapf(err, "cannot write %s to tar", tbe.name)
+	}
+	return nil
+}
+
+func (tbw *TarBagWriter) Create(name string, info fs.FileInfo, compression uint16) (io.WriteCloser, error) {
+	name = strings.TrimLeft(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/")
+	// tar needs the size within the header, so content without file info is buffered
+	if info == nil || !info.Mode().IsRegular() {
+		return &tarBufferEntry{w: tbw.w, name: name, modTime: time.Now()}, nil
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, emperror.Wrap(err, "cannot create tar.FileInfoHeader")
+	}
+	header.Name = name
+	// tar would round to the nearest second, zip truncates
+	header.ModTime = header.ModTime.Truncate(time.Second)
+	if err := tbw.w.WriteHeader(header); err != nil {
+		return nil, emperror.Wrapf(err, "cannot write header of %s to tar", name)
+	}
+	return &tarEntry{w: tbw.w, name: name}, nil
+}
+
+func (tbw *TarBagWriter) Close() error {
+	finalError := emperror.NewMultiErrorBuilder()
+	if err := tbw.w.Close(); err != nil {
+		finalError.Add(err)
+	}
+	if tbw.compressor != nil {
+		if err := tbw.compressor.Close(); err != nil {
+			finalError.Add(err)
+		}
+	}
+	if tbw.closer != nil {
+		if err := tbw.closer.Close(); err != nil {
+			finalError.Add(err)
+		}
+	}
+	return finalError.ErrOrNil()
+}
+
+// TarFS gives random access to the files of an uncompressed tar
+type TarFS struct {
+	r      io.ReaderAt
+	files  map[string]*tarFileInfo
+	dirs   map[string][]fs.DirEntry
+	closer io.Closer
+}
+
+type tarFileInfo struct {
+	fs.FileInfo
+	offset int64
+}
+
+// implicit folders, which have no own entry in the tar
+type tarDirInfo struct {
+	name string
+}
+
+func (tdi tarDirInfo) Name() string       { return tdi.name }
+func (tdi tarDirInfo) Size() int64        { return 0 }
+func (tdi tarDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (tdi tarDirInfo) ModTime() time.Time { return time.Time{} }
+func (tdi tarDirInfo) IsDir() bool        { return true }
+func (tdi tarDirInfo) Sys() any           { return nil }
+
+// NewTarFS reads the headers of an uncompressed tar and remembers the offsets of the file contents
+func NewTarFS(r io.ReaderAt, size int64) (*TarFS, error) {
+	tfs := &TarFS{
+		r:     r,
+		files: map[string]*tarFileInfo{},
+		dirs:  map[string][]fs.DirEntry{".": {}},
+	}
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, emperror.Wrap(err, "cannot read tar header")
+		}
+		name := strings.TrimLeft(path.Clean(header.Name), "/")
+		if name == "." {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			tfs.addDir(name, header.FileInfo())
+		case tar.TypeReg, tar.TypeRegA:
+			// tar reads only whole header blocks, so we are at the beginning of the content
+			offset, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, emperror.Wrapf(err, "cannot get offset of %s", name)
+			}
+			info := &tarFileInfo{FileInfo: header.FileInfo(), offset: offset}
+			if _, ok := tfs.files[name]; !ok {
+				tfs.addDir(path.Dir(name), nil)
+				dir := path.Dir(name)
+				tfs.dirs[dir] = append(tfs.dirs[dir], fs.FileInfoToDirEntry(info))
+			}
+			tfs.files[name] = info
+		default:
+			// links and special files are not part of a bag
+			continue
+		}
+	}
+	for _, entries := range tfs.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return tfs, nil
+}
+
+// addDir registers folder and all its parents
+func (tfs *TarFS) addDir(name string, info fs.FileInfo) {
+	if _, ok := tfs.dirs[name]; ok || name == "." {
+		return
+	}
+	if info == nil {
+		info = tarDirInfo{name: path.Base(name)}
+	}
+	tfs.dirs[name] = []fs.DirEntry{}
+	parent := path.Dir(name)
+	tfs.addDir(parent, nil)
+	tfs.dirs[parent] = append(tfs.dirs[parent], fs.FileInfoToDirEntry(info))
+}
+
+type tarFile struct {
+	*io.SectionReader
+	info fs.FileInfo
+}
+
+func (tf *tarFile) Stat() (fs.FileInfo, error) { return tf.info, nil }
+func (tf *tarFile) Close() error               { return nil }
+
+type tarDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	pos     int
+}
+
+func (td *tarDir) Stat() (fs.FileInfo, error) { return td.info, nil }
+func (td *tarDir) Close() error               { return nil }
+func (td *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: td.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (td *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := td.entries[td.pos:]
+	if n <= 0 {
+		td.pos = len(td.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	td.pos += n
+	return rest[:n], nil
+}
+
+func (tfs *TarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if info, ok := tfs.files[name]; ok {
+		return &tarFile{
+			SectionReader: io.NewSectionReader(tfs.r, info.offset, info.Size()),
+			info:          info,
+		}, nil
+	}
+	if entries, ok := tfs.dirs[name]; ok {
+		return &tarDir{info: tarDirInfo{name: path.Base(name)}, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (tfs *TarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := tfs.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry{}, entries...), nil
+}
+
+func (tfs *TarFS) Close() error {
+	if tfs.closer != nil {
+		return tfs.closer.Close()
+	}
+	return nil
+}
+
+// removes temporary tar after closing
+type tempFile struct {
+	*os.File
+}
+
+func (tf *tempFile) Close() error {
+	err := tf.File.Close()
+	if err2 := os.Remove(tf.Name()); err2 != nil && err == nil {
+		err = err2
+	}
+	return err
+}
+
+// OpenTarFS opens a tar file. compressed tars are decompressed to a temporary file
+// in tempdir for random access
+func OpenTarFS(filename, compression, tempdir string) (*TarFS, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot open %s", filename)
+	}
+	var file *os.File
+	var closer io.Closer
+	switch compression {
+	case TarPlain:
+		file = fp
+		closer = fp
+	case TarGzip, TarZstd:
+		defer fp.Close()
+		var reader io.ReadCloser
+		if compression == TarGzip {
+			if reader, err = gzip.NewReader(fp); err != nil {
+				return nil, emperror.Wrapf(err, "cannot open gzip stream of %s", filename)
+			}
+		} else {
+			dec, err := zstd.NewReader(fp)
+			if err != nil {
+				return nil, emperror.Wrapf(err, "cannot open zstd stream of %s", filename)
+			}
+			reader = dec.IOReadCloser()
+		}
+		defer reader.Close()
+		tmp, err := os.CreateTemp(tempdir, "bagarc-*.tar")
+		if err != nil {
+			return nil, emperror.Wrapf(err, "cannot create temporary file in %s", tempdir)
+		}
+		closer = &tempFile{File: tmp}
+		if _, err := io.Copy(tmp, reader); err != nil {
+			closer.Close()
+			return nil, emperror.Wrapf(err, "cannot decompress %s", filename)
+		}
+		file = tmp
+	default:
+		fp.Close()
+		return nil, errors.New(fmt.Sprintf("unknown tar compression %s", compression))
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		closer.Close()
+		return nil, emperror.Wrapf(err, "cannot stat %s", file.Name())
+	}
+	tfs, err := NewTarFS(file, stat.Size())
+	if err != nil {
+		closer.Close()
+		return nil, emperror.Wrapf(err, "cannot read tar %s", filename)
+	}
+	tfs.closer = closer
+	return tfs, nil
+}
@@ -0,0 +1,81 @@
+package bagit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var tarTestFiles = map[string]string{
+	"a.txt":          "first file",
+	"empty.txt":      "",
+	"sub/b.txt":      strings.Repeat("second file\n", 1000),
+	"sub/deep/c.txt": "third file",
+	"umlaut-äöü.txt": "unicode name",
+	"long/" + strings.Repeat("x", 120) + ".txt": "name longer than 100 bytes",
+}
+
+// tar bags must be readable and valid after creation
+func TestTarRoundTrip(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, tarTestFiles)
+
+	for _, test := range []struct {
+		bagname string
+		magic   []byte
+	}{
+		{"bag.tar", nil},
+		{"bag.tar.gz", []byte{0x1f, 0x8b}},
+		{"bag.tar.zst", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	} {
+		t.Run(test.bagname, func(t *testing.T) {
+			bagfile := filepath.Join(t.TempDir(), test.bagname)
+			createTestBag(t, sourcedir, bagfile, nil)
+			data, err := os.ReadFile(bagfile)
+			if err != nil {
+				t.Fatalf("cannot read %s: %v", bagfile, err)
+			}
+			if !bytes.HasPrefix(data, test.magic) {
+				t.Errorf("%s starts with % x, expected % x", test.bagname, data[:4], test.magic)
+			}
+			validateTestBag(t, bagfile)
+			for name, content := range tarTestFiles {
+				result, err := readBagFile(bagfile, "data/"+name)
+				if err != nil {
+					t.Errorf("cannot read %s: %v", name, err)
+					continue
+				}
+				if string(result) != content {
+					t.Errorf("content of %s differs", name)
+				}
+			}
+		})
+	}
+}
+
+// only uncompressed tar bags can be resumed
+func TestTarResumeCompressed(t *testing.T) {
+	sourcedir := t.TempDir()
+	writeTestTree(t, sourcedir, tarTestFiles)
+
+	for _, bagname := range []string{"bag.tar.gz", "bag.tar.zst"} {
+		t.Run(bagname, func(t *testing.T) {
+			bagfile := filepath.Join(t.TempDir(), bagname)
+			if err := os.WriteFile(bagfile, []byte("partial"), 0644); err != nil {
+				t.Fatalf("cannot write %s: %v", bagfile, err)
+			}
+			bc := newTestCreator(t, sourcedir, bagfile)
+			bc.SetResume(true)
+			err := bc.Run()
+			if err == nil || !strings.Contains(err.Error(), "only uncompressed tar bags can be resumed") {
+				t.Errorf("resume of %s: %v", bagname, err)
+			}
+			// the partial bag is left untouched
+			if data, err := os.ReadFile(bagfile); err != nil || string(data) != "partial" {
+				t.Errorf("partial bag changed: %q %v", data, err)
+			}
+		})
+	}
+}
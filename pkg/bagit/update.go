@@ -0,0 +1,258 @@
+package bagit
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/goph/emperror"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// previousBag is the bag, which is updated
+type previousBag struct {
+	fsys     fs.FS
+	zipFiles map[string]*zip.File  // entries, if bag is a zip
+	records  map[string]*BagitFile // metainfo of payload files with original path as key
+}
+
+// readMetainfo reads bagarc/metainfo.json with original path as key
+func readMetainfo(fsys fs.FS) (map[string]*BagitFile, error) {
+	fp, err := fsys.Open("bagarc/metainfo.json")
+	if err != nil {
+		return nil, emperror.Wrap(err, "cannot open bagarc/metainfo.json")
+	}
+	defer fp.Close()
+	bfs := []*BagitFile{}
+	if err := json.NewDecoder(fp).Decode(&bfs); err != nil {
+		return nil, emperror.Wrap(err, "cannot decode bagarc/metainfo.json")
+	}
+	records := map[string]*BagitFile{}
+	for _, bf := range bfs {
+		records[bf.Path] = bf
+	}
+	return records, nil
+}
+
+// readBagInfo reads all entries of bag-info.txt. continuation lines are joined with newline
+func readBagInfo(fsys fs.FS) (map[string]string, error) {
+	bagInfo := map[string]string{}
+	fp, err := fsys.Open("bag-info.txt")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return bagInfo, nil
+		}
+		return nil, emperror.Wrap(err, "cannot open bag-info.txt")
+	}
+	defer fp.Close()
+	var key string
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key != "" && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			bagInfo[key] += "\n" + strings.TrimSpace(line)
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key = strings.TrimSpace(parts[0])
+		bagInfo[key] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, emperror.Wrap(err, "cannot read bag-info.txt")
+	}
+	return bagInfo, nil
+}
+
+// updateTarget is a sibling of the bag with the same extension
+func updateTarget(bagitfile string) string {
+	return filepath.Join(filepath.Dir(bagitfile), ".update-"+filepath.Base(bagitfile))
+}
+
+// Update creates a new version of the existing bag from the changed source folder.
+// payload files with same size, modification time and checksum are copied from the existing bag
+// (zip entries without recompression), others are added and removed files are dropped.
+// all tag files are regenerated
+func (bc *BagitCreator) Update() error {
+	oldBag := bc.bagitfile
+	fsys, closer, err := OpenBagFS(oldBag, bc.tempdir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closer != nil {
+			closer.Close()
+		}
+	}()
+	records, err := readMetainfo(fsys)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot read metainfo of %s", oldBag)
+	}
+	bagInfo, err := readBagInfo(fsys)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot read bag-info.txt of %s", oldBag)
+	}
+	// keep entries of previous bag-info.txt, which are not overwritten
+	for key, val := range bagInfo {
+		if _, ok := bc.bagInfo[key]; !ok {
+			bc.bagInfo[key] = val
+		}
+	}
+	bc.previous = &previousBag{
+		fsys:     fsys,
+		zipFiles: zipFiles(fsys),
+		records:  records,
+	}
+
+	bc.bagitfile = updateTarget(oldBag)
+	defer func() { bc.bagitfile = oldBag }()
+	if err := os.RemoveAll(bc.bagitfile); err != nil {
+		return emperror.Wrapf(err, "cannot remove %s", bc.bagitfile)
+	}
+	if err := bc.Run(); err != nil {
+		return err
+	}
+	if closer != nil {
+		closer.Close()
+		closer = nil
+	}
+
+	// replace previous bag
+	if BagFormat(oldBag) == FormatFolder {
+		backup := oldBag + ".previous"
+		if err := os.Rename(oldBag, backup); err != nil {
+			return emperror.Wrapf(err, "cannot rename %s", oldBag)
+		}
+		if err := os.Rename(bc.bagitfile, oldBag); err != nil {
+			return emperror.Wrapf(err, "cannot rename %s", bc.bagitfile)
+		}
+		if err := os.RemoveAll(backup); err != nil {
+			return emperror.Wrapf(err, "cannot remove %s", backup)
+		}
+		return nil
+	}
+	if err := os.Rename(bc.bagitfile, oldBag); err != nil {
+		return emperror.Wrapf(err, "cannot rename %s", bc.bagitfile)
+	}
+	return nil
+}
+
+// previousRecord returns the record of the previous bag, if the file is unchanged or nil,
+// if the file has to be added. a file may change within the resolution of the modification time,
+// so unchanged size and modification time are confirmed with the checksum of the source
+func (bc *BagitCreator) previousRecord(bf *BagitFile) *BagitFile {
+	old, ok := bc.previous.records[bf.Path]
+	if !ok || old.Type != "" || old.Size != bf.Size {
+		return nil
+	}
+	for _, cs := range bc.checksum {
+		if _, ok := old.Checksum[cs]; !ok {
+			return nil
+		}
+	}
+	info, err := fs.Stat(bc.previous.fsys, path.Join("data", old.ZipPath))
+	if err != nil || info.Size() != bf.Size {
+		return nil
+	}
+	// metainfo.json knows the exact time, zip entries only whole seconds
+	if old.ModTime != nil {
+		if !old.ModTime.Equal(bf.info.ModTime()) {
+			return nil
+		}
+	} else if info.ModTime().Unix() != bf.info.ModTime().Unix() {
+		return nil
+	}
+	fullpath := filepath.Join(bf.baseDir, bf.Path)
+	fp, err := os.Open(fullpath)
+	if err != nil {
+		return nil
+	}
+	defer fp.Close()
+	if ok, err := verifyRecord(fp, old); err != nil || !ok {
+		bc.logger.Infof("%s changed without new modification time", bf.Path)
+		return nil
+	}
+	return old
+}
+
+// copyPrevious copies an unchanged file from the previous bag
+func (bc *BagitCreator) copyPrevious(old, bf *BagitFile, bagWriter BagWriter) error {
+	if err := copyEntry(bc.previous.fsys, bc.previous.zipFiles, path.Join("data", old.ZipPath), path.Join("data", bf.ZipPath), bagWriter); err != nil {
+		return err
+	}
+	old.ZipPath = bf.ZipPath
+	return nil
+}
+
+// zipFiles returns the entries of a zip container by name, nil for other containers
+func zipFiles(fsys fs.FS) map[string]*zip.File {
+	var files []*zip.File
+	switch zr := fsys.(type) {
+	case *zip.ReadCloser:
+		files = zr.File
+	case *zip.Reader:
+		files = zr.File
+	default:
+		return nil
+	}
+	result := map[string]*zip.File{}
+	for _, f := range files {
+		result[f.Name] = f
+	}
+	return result
+}
+
+// copyEntry copies a file from fsys to the bag. zip entries are copied to zip without recompression
+func copyEntry(fsys fs.FS, zipFiles map[string]*zip.File, name, newName string, bagWriter BagWriter) error {
+	if zf, ok := zipFiles[name]; ok {
+		if zbw, ok := bagWriter.(*ZipBagWriter); ok {
+			return zbw.CopyRaw(zf, newName)
+		}
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot stat %s", name)
+	}
+	src, err := fsys.Open(name)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot open %s", name)
+	}
+	defer src.Close()
+	dst, err := bagWriter.Create(newName, info, zip.Deflate)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot create %s in bag", newName)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return emperror.Wrapf(err, "cannot copy %s", name)
+	}
+	if err := dst.Close(); err != nil {
+		return emperror.Wrapf(err, "cannot close %s in bag", newName)
+	}
+	return nil
+}
+
+// CopyRaw copies a zip entry without recompression
+func (zbw *ZipBagWriter) CopyRaw(f *zip.File, name string) error {
+	reader, err := f.OpenRaw()
+	if err != nil {
+		return emperror.Wrapf(err, "cannot open raw source %s", f.Name)
+	}
+	header := f.FileHeader
+	header.Name = name
+	writer, err := zbw.w.CreateRaw(&header)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot create raw target %s", name)
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		return emperror.Wrapf(err, "cannot raw copy %s", f.Name)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package bagit
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	for _, bagname := range []string{"bag.zip", "bag"} {
+		t.Run(bagname, func(t *testing.T) {
+			sourcedir := t.TempDir()
+			writeTestTree(t, sourcedir, map[string]string{
+				"unchanged.txt":   strings.Repeat("unchanged ", 100),
+				"modified.txt":    strings.Repeat("original ", 100),
+				"samesecond.txt":  strings.Repeat("original ", 100),
+				"sub/deleted.txt": "deleted",
+			})
+			bagfile := filepath.Join(t.TempDir(), bagname)
+			// the previous bag stores all files, added files are deflated
+			createTestBag(t, sourcedir, bagfile, func(bc *BagitCreator) {
+				if err := bc.SetCompressionRules([]CompressionRule{{Ext: []string{"txt"}, Method: CompressionStore}}); err != nil {
+					t.Fatalf("cannot set compression rules: %v", err)
+				}
+			})
+
+			writeTestTree(t, sourcedir, map[string]string{
+				"modified.txt": strings.Repeat("modified and longer ", 100),
+				"added.txt":    "added",
+			})
+			// same size and modification time
+			samesecond := filepath.Join(sourcedir, "samesecond.txt")
+			info, err := os.Stat(samesecond)
+			if err != nil {
+				t.Fatalf("cannot stat %s: %v", samesecond, err)
+			}
+			writeTestTree(t, sourcedir, map[string]string{"samesecond.txt": strings.Repeat("changed! ", 100)})
+			if err := os.Chtimes(samesecond, time.Now(), info.ModTime()); err != nil {
+				t.Fatalf("cannot set time of %s: %v", samesecond, err)
+			}
+			if err := os.Remove(filepath.Join(sourcedir, "sub", "deleted.txt")); err != nil {
+				t.Fatalf("cannot remove deleted.txt: %v", err)
+			}
+
+			bc := newTestCreator(t, sourcedir, bagfile)
+			if err := bc.Update(); err != nil {
+				t.Fatalf("cannot update %s: %v", bagfile, err)
+			}
+			validateTestBag(t, bagfile)
+			if _, err := os.Stat(updateTarget(bagfile)); err == nil {
+				t.Errorf("%s not removed", updateTarget(bagfile))
+			}
+
+			for name, content := range map[string]string{
+				"unchanged.txt":  strings.Repeat("unchanged ", 100),
+				"modified.txt":   strings.Repeat("modified and longer ", 100),
+				"samesecond.txt": strings.Repeat("changed! ", 100),
+				"added.txt":      "added",
+			} {
+				data, err := readBagFile(bagfile, "data/"+name)
+				if err != nil || string(data) != content {
+					t.Errorf("%s: %q %v, expected %q", name, shorten(string(data)), err, shorten(content))
+				}
+			}
+			if _, err := readBagFile(bagfile, "data/sub/deleted.txt"); err == nil {
+				t.Errorf("deleted file in bag")
+			}
+			metainfo := readTestMetainfo(t, bagfile)
+			for _, bf := range metainfo {
+				if bf.Path == "/sub/deleted.txt" {
+					t.Errorf("deleted file in metainfo.json")
+				}
+			}
+
+			if BagFormat(bagname) != FormatZip {
+				return
+			}
+			// unchanged files are copied without recompression
+			methods := map[string]uint16{}
+			for _, entry := range readZipEntries(t, bagfile) {
+				methods[entry.name] = entry.method
+			}
+			for name, method := range map[string]uint16{
+				"data/unchanged.txt":  zip.Store,
+				"data/modified.txt":   zip.Deflate,
+				"data/samesecond.txt": zip.Deflate,
+				"data/added.txt":      zip.Deflate,
+			} {
+				if methods[name] != method {
+					t.Errorf("method of %s is %v, expected %v", name, methods[name], method)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package bagit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// percent-encoding of paths in manifests and fetch.txt (BagIt 1.0)
+var pathEncoder = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+var pathDecoder = strings.NewReplacer("%25", "%", "%0D", "\r", "%0d", "\r", "%0A", "\n", "%0a", "\n")
+
+// CheckVersion returns an error, if bags of this version cannot be created
+func CheckVersion(version string) error {
+	switch version {
+	case BAGITVERSION, BAGITVERSION1:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("unsupported BagIt version %s (%s or %s)", version, BAGITVERSION, BAGITVERSION1))
+	}
+}
+
+// DefaultChecksums returns the checksums for new bags of the given version
+func DefaultChecksums(version string) []string {
+	if isVersion1(version) {
+		return []string{"sha512"}
+	}
+	return []string{"md5", "sha512"}
+}
+
+// isVersion1 checks for BagIt 1.0 or newer
+func isVersion1(version string) bool {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return err == nil && major >= 1
+}
+
+// encodePath encodes a path for manifests and fetch.txt.
+// since BagIt 1.0 CR, LF and % are percent-encoded
+func encodePath(version, name string) string {
+	if !isVersion1(version) {
+		return name
+	}
+	return pathEncoder.Replace(name)
+}
+
+// decodePath decodes a path of manifests and fetch.txt
+func decodePath(version, name string) string {
+	if !isVersion1(version) {
+		return name
+	}
+	return pathDecoder.Replace(name)
+}
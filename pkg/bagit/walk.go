@@ -0,0 +1,202 @@
+package bagit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/goph/emperror"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LinkPolicy defines the handling of symbolic links in the source folder
+type LinkPolicy string
+
+const (
+	LinkFollow LinkPolicy = "follow" // store the content of the target
+	LinkRecord LinkPolicy = "record" // store only the link target in bagarc/metainfo.json
+	LinkSkip   LinkPolicy = "skip"   // ignore with a warning
+)
+
+// ParseLinkPolicy converts a string to a LinkPolicy
+func ParseLinkPolicy(str string) (LinkPolicy, error) {
+	switch policy := LinkPolicy(strings.ToLower(strings.TrimSpace(str))); policy {
+	case LinkFollow, LinkRecord, LinkSkip:
+		return policy, nil
+	default:
+		return "", errors.New(fmt.Sprintf("unknown symlink policy %s (follow|record|skip)", str))
+	}
+}
+
+// sourceEntry is a file, link or directory of the source folder
+type sourceEntry struct {
+	path  string
+	info  os.FileInfo // target of followed links
+	empty bool        // directory without entries
+}
+
+// excludedEntry is a file of the source folder, which is not part of the bag.
+// name is relative to the source folder
+type excludedEntry struct {
+	name   string
+	reason string
+}
+
+// scanSource walks through the source folder. symbolic links are handled according to
+// the link policy, devices, named pipes and sockets are skipped. files are selected by
+// filter, depth and size
+func (bc *BagitCreator) scanSource() ([]*sourceEntry, error) {
+	realDir, err := realPath(bc.sourcedir)
+	if err != nil {
+		return nil, err
+	}
+	bc.excluded = []excludedEntry{}
+	entries := []*sourceEntry{}
+	if err := bc.scanDir(filepath.FromSlash(bc.sourcedir), "", realDir, &entries); err != nil {
+		return nil, emperror.Wrapf(err, "cannot walk filesystem")
+	}
+	return entries, nil
+}
+
+// scanDir adds the content of dir. name is the slash separated path relative to the source folder,
+// realDir is the path without symbolic links to detect loops
+func (bc *BagitCreator) scanDir(dir, name, realDir string, entries *[]*sourceEntry) error {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return emperror.Wrapf(err, "cannot read folder %s", dir)
+	}
+	for _, dirEntry := range dirEntries {
+		fullpath := filepath.Join(dir, dirEntry.Name())
+		entryName := path.Join(name, dirEntry.Name())
+		info, err := os.Lstat(fullpath)
+		if err != nil {
+			return emperror.Wrapf(err, "cannot stat %s", fullpath)
+		}
+		realTarget := filepath.Join(realDir, dirEntry.Name())
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch bc.linkPolicy {
+			case LinkSkip:
+				bc.skip(entryName, "symbolic link")
+				continue
+			case LinkRecord:
+				if reason := bc.filterFile(entryName, info); reason != "" {
+					bc.exclude(entryName, reason)
+					continue
+				}
+				*entries = append(*entries, &sourceEntry{path: fullpath, info: info})
+				continue
+			}
+			if info, err = os.Stat(fullpath); err != nil {
+				bc.skip(entryName, "dangling symbolic link")
+				continue
+			}
+			if !info.IsDir() {
+				if reason := bc.filterFile(entryName, info); reason != "" {
+					bc.exclude(entryName, reason)
+					continue
+				}
+				*entries = append(*entries, &sourceEntry{path: fullpath, info: info})
+				continue
+			}
+			if realTarget, err = realPath(fullpath); err != nil {
+				return err
+			}
+			// a link to a parent folder would never end
+			if strings.HasPrefix(realDir+string(filepath.Separator), realTarget+string(filepath.Separator)) {
+				bc.skip(entryName, "symbolic link loop")
+				continue
+			}
+		}
+		switch {
+		case info.IsDir():
+			if pattern := bc.filter.Excluded(entryName, true); pattern != "" {
+				if err := bc.excludeDir(fullpath, entryName, fmt.Sprintf("exclude pattern %s", pattern)); err != nil {
+					return err
+				}
+				continue
+			}
+			entry := &sourceEntry{path: fullpath, info: info}
+			*entries = append(*entries, entry)
+			count := len(*entries)
+			if err := bc.scanDir(fullpath, entryName, realTarget, entries); err != nil {
+				return err
+			}
+			entry.empty = len(*entries) == count
+		case info.Mode().IsRegular():
+			if reason := bc.filterFile(entryName, info); reason != "" {
+				bc.exclude(entryName, reason)
+				continue
+			}
+			*entries = append(*entries, &sourceEntry{path: fullpath, info: info})
+		default:
+			bc.skip(entryName, "special file")
+		}
+	}
+	return nil
+}
+
+// realPath returns the absolute path without symbolic links
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", emperror.Wrapf(err, "cannot get absolute path of %s", name)
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", emperror.Wrapf(err, "cannot resolve %s", name)
+	}
+	return real, nil
+}
+
+// filterFile returns the reason, why a file is not added to the bag or an empty string
+func (bc *BagitCreator) filterFile(name string, info os.FileInfo) string {
+	if pattern := bc.filter.Excluded(name, false); pattern != "" {
+		return fmt.Sprintf("exclude pattern %s", pattern)
+	}
+	if !bc.filter.Included(name) {
+		return "no include pattern"
+	}
+	if depth := strings.Count(name, "/") + 1; bc.maxDepth > 0 && depth > bc.maxDepth {
+		return fmt.Sprintf("depth %v > %v", depth, bc.maxDepth)
+	}
+	if bc.maxSize > 0 && info.Size() > bc.maxSize {
+		return fmt.Sprintf("size %s > %s", humanize.Bytes(uint64(info.Size())), humanize.Bytes(uint64(bc.maxSize)))
+	}
+	return ""
+}
+
+// excludeDir lists all files of an excluded folder
+func (bc *BagitCreator) excludeDir(dir, name, reason string) error {
+	bc.logger.Infof("excluding %s: %s", name, reason)
+	if err := filepath.WalkDir(dir, func(fullpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, fullpath)
+		if err != nil {
+			return err
+		}
+		bc.excluded = append(bc.excluded, excludedEntry{name: path.Join(name, filepath.ToSlash(rel)), reason: reason})
+		return nil
+	}); err != nil {
+		return emperror.Wrapf(err, "cannot walk %s", dir)
+	}
+	return nil
+}
+
+func (bc *BagitCreator) exclude(name, reason string) {
+	bc.logger.Infof("excluding %s: %s", name, reason)
+	bc.excluded = append(bc.excluded, excludedEntry{name: name, reason: reason})
+}
+
+// skip excludes a file, which cannot be stored
+func (bc *BagitCreator) skip(name, reason string) {
+	bc.logger.Warningf("skipping %s: %s", name, reason)
+	bc.excluded = append(bc.excluded, excludedEntry{name: name, reason: reason})
+}
@@ -0,0 +1,199 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/goph/emperror"
+	"github.com/op/go-logging"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaults of NewClient
+const (
+	DefaultTimeout     = 10 * time.Minute // files are downloaded and analyzed by the indexer
+	DefaultRetries     = 3
+	DefaultBackoff     = 2 * time.Second
+	DefaultMaxBackoff  = time.Minute
+	DefaultConcurrency = 4
+)
+
+// Query is the request for the indexer service
+type Query struct {
+	Url           string   `json:"url"`
+	Actions       []string `json:"actions,omitempty"`
+	ForceDownload string   `json:"forcedownload,omitempty"`
+	Headersize    int64    `json:"headersize,omitempty"`
+}
+
+// StatusError is the result of a request with a status code other than 2xx
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string // beginning of the response
+}
+
+func (se *StatusError) Error() string {
+	return fmt.Sprintf("indexer returned %s: %s", se.Status, se.Body)
+}
+
+// AsStatusError finds a StatusError within the causes of err
+func AsStatusError(err error) (*StatusError, bool) {
+	var se *StatusError
+	emperror.ForEachCause(err, func(err error) bool {
+		var ok bool
+		se, ok = err.(*StatusError)
+		return !ok
+	})
+	return se, se != nil
+}
+
+// temporary errors are retried
+func (se *StatusError) temporary() bool {
+	return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests || se.StatusCode == http.StatusRequestTimeout
+}
+
+// Client calls the indexer service with timeout and retries. the number of concurrent requests is limited,
+// so that parallel workers do not overload the service
+type Client struct {
+	url        string
+	httpClient *http.Client
+	timeout    time.Duration // per request
+	retries    int
+	backoff    time.Duration // doubled after every retry
+	maxBackoff time.Duration
+	sem        chan struct{}
+	logger     *logging.Logger
+}
+
+// NewClient creates a client for the indexer at url with at most concurrency parallel requests
+func NewClient(url string, concurrency int, logger *logging.Logger) *Client {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Client{
+		url:        url,
+		httpClient: &http.Client{},
+		timeout:    DefaultTimeout,
+		retries:    DefaultRetries,
+		backoff:    DefaultBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		sem:        make(chan struct{}, concurrency),
+		logger:     logger,
+	}
+}
+
+// SetHTTPClient replaces the default client e.g. for tls settings or tests
+func (c *Client) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+}
+
+// SetTimeout limits the duration of a single request. 0 for no timeout
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetRetries defines the number of retries after temporary errors and the exponential backoff
+// from backoff to maxBackoff
+func (c *Client) SetRetries(retries int, backoff, maxBackoff time.Duration) {
+	c.retries = retries
+	c.backoff = backoff
+	c.maxBackoff = maxBackoff
+}
+
+// Url returns the address of the indexer service
+func (c *Client) Url() string { return c.url }
+
+// Index sends the query to the indexer. network errors, timeouts and 5xx responses are retried
+func (c *Client) Index(ctx context.Context, query *Query) (*Result, error) {
+	data, err := json.Marshal(query)
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot marshal json")
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		result, err := c.post(ctx, data)
+		if err == nil {
+			return result, nil
+		}
+		if attempt >= c.retries || !temporary(err) || ctx.Err() != nil {
+			return nil, emperror.Wrapf(err, "cannot index %s", query.Url)
+		}
+		c.logger.Warningf("indexer error for %s (retry %v/%v in %v): %v", query.Url, attempt+1, c.retries, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, emperror.Wrapf(ctx.Err(), "cannot index %s", query.Url)
+		}
+		if backoff *= 2; backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// post does a single request within the concurrency limit. errors of the http client
+// are not wrapped, so that temporary can check them
+func (c *Client) post(ctx context.Context, data []byte) (*Result, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.sem }()
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, emperror.Wrapf(err, "cannot create request for %s", c.url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(body) > 200 {
+			body = body[:200]
+		}
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+	return NewResult(body)
+}
+
+// temporary checks, whether a retry makes sense
+func temporary(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.temporary()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// every error of http.Client is a net.Error, only timeouts and errors of the connection are retried
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,225 @@
+package indexer
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/op/go-logging"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var testLogger = func() *logging.Logger {
+	backend := logging.AddModuleLevel(logging.NewLogBackend(io.Discard, "", 0))
+	backend.SetLevel(logging.ERROR, "")
+	logging.SetBackend(backend)
+	return logging.MustGetLogger("indexer_test")
+}()
+
+const testResult = `{"siegfried":[{"ns":"pronom","id":"fmt/353","format":"Tagged Image File Format","mime":"image/tiff"}]}`
+
+// newTestServer answers with the status of statuses for every request. the last status is repeated
+func newTestServer(t *testing.T, attempts *int32, statuses ...int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := int(atomic.AddInt32(attempts, 1)) - 1
+		status := statuses[len(statuses)-1]
+		if attempt < len(statuses) {
+			status = statuses[attempt]
+		}
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, testResult)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClient(url string, concurrency int) *Client {
+	client := NewClient(url, concurrency, testLogger)
+	client.SetRetries(3, time.Millisecond, 5*time.Millisecond)
+	return client
+}
+
+func TestIndexRetry(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			var attempts int32
+			server := newTestServer(t, &attempts, status, status, http.StatusOK)
+			result, err := newTestClient(server.URL, 1).Index(context.Background(), &Query{Url: "file:///test.tif"})
+			if err != nil {
+				t.Fatalf("cannot index: %v", err)
+			}
+			if n := atomic.LoadInt32(&attempts); n != 3 {
+				t.Errorf("%v attempts, expected 3", n)
+			}
+			if pronom, mime, err := result.PRONOM(); err != nil || pronom != "fmt/353" || mime != "image/tiff" {
+				t.Errorf("result %s %s %v, expected fmt/353 image/tiff", pronom, mime, err)
+			}
+		})
+	}
+}
+
+func TestIndexRetryExhausted(t *testing.T) {
+	var attempts int32
+	server := newTestServer(t, &attempts, http.StatusServiceUnavailable)
+	_, err := newTestClient(server.URL, 1).Index(context.Background(), &Query{Url: "file:///test.tif"})
+	if err == nil {
+		t.Fatal("no error after all retries")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 4 {
+		t.Errorf("%v attempts, expected 4", n)
+	}
+	if se, ok := AsStatusError(err); !ok || se.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("error %v is no StatusError with 503", err)
+	}
+}
+
+func TestIndexNoRetry(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			var attempts int32
+			server := newTestServer(t, &attempts, status, http.StatusOK)
+			_, err := newTestClient(server.URL, 1).Index(context.Background(), &Query{Url: "file:///test.tif"})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if n := atomic.LoadInt32(&attempts); n != 1 {
+				t.Errorf("%v attempts, expected 1", n)
+			}
+			se, ok := AsStatusError(err)
+			if !ok {
+				t.Fatalf("error %v is no StatusError", err)
+			}
+			if se.StatusCode != status || se.Body != http.StatusText(status) {
+				t.Errorf("status error %v, expected %v %s", se, status, http.StatusText(status))
+			}
+		})
+	}
+}
+
+func TestIndexTimeout(t *testing.T) {
+	var attempts int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	client := newTestClient(server.URL, 1)
+	client.SetTimeout(50 * time.Millisecond)
+	client.SetRetries(1, time.Millisecond, time.Millisecond)
+	start := time.Now()
+	_, err := client.Index(context.Background(), &Query{Url: "file:///test.tif"})
+	if err == nil {
+		t.Fatal("no error after timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timeout after %v", elapsed)
+	}
+	// timeouts are retried
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("%v attempts, expected 2", n)
+	}
+}
+
+func TestIndexConcurrency(t *testing.T) {
+	const concurrency = 3
+	var inflight, maxInflight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, testResult)
+	}))
+	defer server.Close()
+	client := newTestClient(server.URL, concurrency)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := client.Index(context.Background(), &Query{Url: fmt.Sprintf("file:///test%v.tif", i)}); err != nil {
+				t.Errorf("cannot index: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&maxInflight); n != concurrency {
+		t.Errorf("%v concurrent requests, expected %v", n, concurrency)
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		err       error
+		temporary bool
+	}{
+		{"5xx", &StatusError{StatusCode: http.StatusBadGateway}, true},
+		{"429", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"4xx", &StatusError{StatusCode: http.StatusNotFound}, false},
+		{"timeout", &url.Error{Op: "Post", URL: "http://indexer", Err: context.DeadlineExceeded}, true},
+		{"refused", &url.Error{Op: "Post", URL: "http://indexer", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, true},
+		{"reset", &url.Error{Op: "Post", URL: "http://indexer", Err: fmt.Errorf("read: %w", syscall.ECONNRESET)}, true},
+		{"eof", &url.Error{Op: "Post", URL: "http://indexer", Err: io.ErrUnexpectedEOF}, true},
+		{"scheme", &url.Error{Op: "Post", URL: "ftp://indexer", Err: errors.New("unsupported protocol scheme")}, false},
+		{"certificate", &url.Error{Op: "Post", URL: "https://indexer", Err: x509.UnknownAuthorityError{}}, false},
+		{"redirect", &url.Error{Op: "Post", URL: "http://indexer", Err: http.ErrUseLastResponse}, false},
+	} {
+		if result := temporary(test.err); result != test.temporary {
+			t.Errorf("%s: temporary is %v, expected %v", test.name, result, test.temporary)
+		}
+	}
+}
+
+// errors of tls are not retried, refused connections are
+func TestIndexNetworkErrors(t *testing.T) {
+	var attempts int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+	}))
+	defer server.Close()
+	client := newTestClient(server.URL, 1)
+	client.SetRetries(3, time.Second, time.Second)
+	start := time.Now()
+	if _, err := client.Index(context.Background(), &Query{Url: "file:///test.tif"}); err == nil {
+		t.Error("no error with unknown certificate")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("certificate error retried (%v)", elapsed)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	client = newTestClient(closed.URL, 1)
+	client.SetRetries(2, 20*time.Millisecond, 20*time.Millisecond)
+	start = time.Now()
+	if _, err := client.Index(context.Background(), &Query{Url: "file:///test.tif"}); err == nil {
+		t.Error("no error with closed server")
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("refused connection not retried (%v)", elapsed)
+	}
+}
@@ -0,0 +1,215 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/goph/emperror"
+	"strings"
+)
+
+// ErrNoResult is returned for checks, which are not part of the answer
+var ErrNoResult = errors.New("no result")
+
+// SFIdentifier is a signature file of siegfried
+type SFIdentifier struct {
+	Name    string `json:"name,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+// SFMatches is a format identified by siegfried. Id is the PRONOM id for namespace pronom
+type SFMatches struct {
+	Ns      string `json:"ns,omitempty"`
+	Id      string `json:"id,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Version string `json:"version,omitempty"`
+	Mime    string `json:"mime,omitempty"`
+	Basis   string `json:"basis,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+type SFFiles struct {
+	Filename string      `json:"filename,omitempty"`
+	Filesize int64       `json:"filesize,omitempty"`
+	Modified string      `json:"modified,omitempty"`
+	Errors   string      `json:"errors,omitempty"`
+	Matches  []SFMatches `json:"matches,omitempty"`
+}
+
+// SF is the complete output of siegfried
+type SF struct {
+	Siegfried   string         `json:"siegfried,omitempty"`
+	Scandate    string         `json:"scandate,omitempty"`
+	Signature   string         `json:"signature,omitempty"`
+	Created     string         `json:"created,omitempty"`
+	Identifiers []SFIdentifier `json:"identifiers,omitempty"`
+	Files       []SFFiles      `json:"files,omitempty"`
+}
+
+// Identify is the json output of imagemagick
+type Identify struct {
+	Image IdentifyImage `json:"image"`
+}
+
+type IdentifyImage struct {
+	Format   string `json:"format,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	Class    string `json:"class,omitempty"`
+	Geometry struct {
+		Width  int64 `json:"width"`
+		Height int64 `json:"height"`
+	} `json:"geometry"`
+	Units      string `json:"units,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Colorspace string `json:"colorspace,omitempty"`
+	Depth      int64  `json:"depth,omitempty"`
+	Resolution struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+	} `json:"resolution"`
+	Compression string `json:"compression,omitempty"`
+	Orientation string `json:"orientation,omitempty"`
+}
+
+// FFProbe is the json output of ffprobe with -show_format and -show_streams.
+// numbers of the format and rates are strings
+type FFProbe struct {
+	Streams []FFProbeStream `json:"streams,omitempty"`
+	Format  FFProbeFormat   `json:"format"`
+}
+
+type FFProbeStream struct {
+	Index         int64             `json:"index"`
+	CodecName     string            `json:"codec_name,omitempty"`
+	CodecLongName string            `json:"codec_long_name,omitempty"`
+	CodecType     string            `json:"codec_type,omitempty"` // video, audio, subtitle or data
+	Width         int64             `json:"width,omitempty"`
+	Height        int64             `json:"height,omitempty"`
+	PixFmt        string            `json:"pix_fmt,omitempty"`
+	SampleRate    string            `json:"sample_rate,omitempty"`
+	Channels      int64             `json:"channels,omitempty"`
+	Duration      string            `json:"duration,omitempty"`
+	BitRate       string            `json:"bit_rate,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type FFProbeFormat struct {
+	Filename       string            `json:"filename,omitempty"`
+	NbStreams      int64             `json:"nb_streams,omitempty"`
+	FormatName     string            `json:"format_name,omitempty"`
+	FormatLongName string            `json:"format_long_name,omitempty"`
+	Duration       string            `json:"duration,omitempty"`
+	Size           string            `json:"size,omitempty"`
+	BitRate        string            `json:"bit_rate,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+// ClamAV is the virus scan result with the status (OK or name of the virus) of every scanned file
+type ClamAV map[string]string
+
+// Infected returns the names of the found viruses
+func (c ClamAV) Infected() []string {
+	viruses := []string{}
+	for _, status := range c {
+		if status != "" && !strings.EqualFold(status, "OK") {
+			viruses = append(viruses, status)
+		}
+	}
+	return viruses
+}
+
+// Result is the answer of the indexer. the results of the checks are decoded on demand,
+// so that an unexpected structure of one check does not break the others
+type Result struct {
+	raw    map[string]json.RawMessage
+	values map[string]interface{}
+}
+
+// NewResult parses the json answer of the indexer
+func NewResult(data []byte) (*Result, error) {
+	result := &Result{}
+	if err := json.Unmarshal(data, &result.raw); err != nil {
+		return nil, emperror.Wrapf(err, "cannot unmarshal result")
+	}
+	if err := json.Unmarshal(data, &result.values); err != nil {
+		return nil, emperror.Wrapf(err, "cannot unmarshal result")
+	}
+	return result, nil
+}
+
+// Map returns the untyped result e.g. for storing
+func (r *Result) Map() map[string]interface{} {
+	return r.values
+}
+
+// Has checks for the result of a check
+func (r *Result) Has(check string) bool {
+	_, ok := r.raw[check]
+	return ok
+}
+
+// Decode unmarshals the result of check into v
+func (r *Result) Decode(check string, v interface{}) error {
+	data, ok := r.raw[check]
+	if !ok {
+		return emperror.Wrapf(ErrNoResult, "%s", check)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return emperror.Wrapf(err, "cannot unmarshal %s result", check)
+	}
+	return nil
+}
+
+// Errors returns the errors of the checks
+func (r *Result) Errors() (map[string]string, error) {
+	errs := map[string]string{}
+	if !r.Has("errors") {
+		return errs, nil
+	}
+	return errs, r.Decode("errors", &errs)
+}
+
+// Siegfried returns the matches of siegfried
+func (r *Result) Siegfried() ([]SFMatches, error) {
+	matches := []SFMatches{}
+	return matches, r.Decode("siegfried", &matches)
+}
+
+// Identify returns the image properties of imagemagick
+func (r *Result) Identify() (*Identify, error) {
+	identify := &Identify{}
+	if err := r.Decode("identify", identify); err != nil {
+		return nil, err
+	}
+	return identify, nil
+}
+
+// FFProbe returns streams and container format of ffprobe
+func (r *Result) FFProbe() (*FFProbe, error) {
+	ffprobe := &FFProbe{}
+	if err := r.Decode("ffprobe", ffprobe); err != nil {
+		return nil, err
+	}
+	return ffprobe, nil
+}
+
+// ClamAV returns the result of the virus scan
+func (r *Result) ClamAV() (ClamAV, error) {
+	clamav := ClamAV{}
+	return clamav, r.Decode("clamav", &clamav)
+}
+
+// PRONOM returns id and MIME type of the first siegfried match
+func (r *Result) PRONOM() (string, string, error) {
+	matches, err := r.Siegfried()
+	if err != nil {
+		return "", "", err
+	}
+	if len(matches) == 0 {
+		return "", "", emperror.Wrapf(ErrNoResult, "siegfried is empty")
+	}
+	mime := matches[0].Mime
+	if i := strings.Index(mime, ";"); i >= 0 {
+		mime = mime[:i]
+	}
+	return matches[0].Id, strings.ToLower(strings.TrimSpace(mime)), nil
+}
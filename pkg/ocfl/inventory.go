@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/goph/emperror"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
 	"github.com/op/go-logging"
 	"path/filepath"
 	"regexp"
@@ -88,7 +89,11 @@ func (i *Inventory) GetDigestAlgorithm() DigestAlgorithm { return i.DigestAlgori
 func (i *Inventory) IsWriteable() bool                   { return i.writeable }
 func (i *Inventory) IsModified() bool                    { return i.modified }
 func (i *Inventory) BuildRealname(virtualFilename string) string {
-	return fmt.Sprintf("%s/%s/%s", i.GetVersion(), i.GetContentDirectory(), FixFilename(filepath.ToSlash(virtualFilename)))
+	return i.buildRealname(virtualFilename, fixFilenameRules)
+}
+
+func (i *Inventory) buildRealname(virtualFilename string, rules *sanitize.RuleSet) string {
+	return fmt.Sprintf("%s/%s/%s", i.GetVersion(), i.GetContentDirectory(), rules.Sanitize(filepath.ToSlash(virtualFilename)))
 }
 
 func (i *Inventory) NewVersion(msg, UserName, UserAddress string) error {
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/goph/emperror"
+	"github.com/je4/bagarc/v2/pkg/sanitize"
 	"github.com/op/go-logging"
 	"io"
 	"io/fs"
@@ -18,11 +19,13 @@ const VERSION = "1.0"
 var rootConformanceDeclaration = fmt.Sprintf("0=ocfl_%s", VERSION)
 
 type OCFLObject struct {
-	fs         OCFLFS
-	pathPrefix string
-	i          *Inventory
-	changed    bool
-	logger     *logging.Logger
+	fs            OCFLFS
+	pathPrefix    string
+	i             *Inventory
+	changed       bool
+	rules         *sanitize.RuleSet      // rules for content filenames
+	normalization sanitize.Normalization // unicode normalization of logical filenames
+	logger        *logging.Logger
 }
 
 // NewOCFL creates an empty OCFL structure
@@ -32,7 +35,7 @@ func NewOCFLObject(fs OCFLFS, pathPrefix, id string, logger *logging.Logger) (*O
 	}
 	// no / prefix, but / suffix
 	pathPrefix = strings.Trim(pathPrefix, "/") + "/"
-	ocfl := &OCFLObject{fs: fs, logger: logger, pathPrefix: pathPrefix}
+	ocfl := &OCFLObject{fs: fs, logger: logger, pathPrefix: pathPrefix, rules: fixFilenameRules}
 
 	if err := ocfl.Init(id); err != nil {
 		return nil, emperror.Wrap(err, "cannot initialize ocfl")
@@ -216,9 +219,24 @@ func (ocfl *OCFLObject) StartUpdate(msg string, UserName string, UserAddress str
 	return nil
 }
 
+// SetFilenameRules replaces the default rules (sanitize.WheelerOCFL) for content filenames
+func (ocfl *OCFLObject) SetFilenameRules(rules *sanitize.RuleSet) {
+	ocfl.rules = rules
+}
+
+// SetNormalization converts all logical filenames to a unicode normalization form
+func (ocfl *OCFLObject) SetNormalization(normalization sanitize.Normalization) {
+	ocfl.normalization = normalization
+}
+
 func (ocfl *OCFLObject) AddFile(virtualFilename string, reader io.Reader, checksum string) error {
 	virtualFilename = filepath.ToSlash(virtualFilename)
 	ocfl.logger.Debugf("%s [%s]", virtualFilename, checksum)
+	// otherwise the same name in another normalization form is no duplicate
+	if normalized := ocfl.normalization.Apply(virtualFilename); normalized != virtualFilename {
+		ocfl.logger.Debugf("%s normalized to %s", virtualFilename, normalized)
+		virtualFilename = normalized
+	}
 
 	if !ocfl.i.IsWriteable() {
 		return errors.New("ocfl not writeable")
@@ -233,7 +251,7 @@ func (ocfl *OCFLObject) AddFile(virtualFilename string, reader io.Reader, checks
 		ocfl.logger.Debugf("%s [%s] is a duplicate", virtualFilename, checksum)
 		return nil
 	}
-	realFilename := ocfl.i.BuildRealname(virtualFilename)
+	realFilename := ocfl.i.buildRealname(virtualFilename, ocfl.rules)
 	writer, err := ocfl.fs.Create(ocfl.pathPrefix + realFilename)
 	if err != nil {
 		return emperror.Wrapf(err, "cannot create %s", realFilename)
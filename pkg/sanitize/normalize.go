@@ -0,0 +1,42 @@
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+)
+
+// Normalization is the unicode normalization form of filenames.
+// macOS delivers NFD, most other systems NFC
+type Normalization string
+
+const (
+	NormalizeNone Normalization = ""    // keep names as they are
+	NormalizeNFC  Normalization = "nfc" // composed form
+	NormalizeNFD  Normalization = "nfd" // decomposed form
+)
+
+// ParseNormalization converts a string to a Normalization
+func ParseNormalization(str string) (Normalization, error) {
+	switch n := Normalization(strings.ToLower(strings.TrimSpace(str))); n {
+	case NormalizeNone, NormalizeNFC, NormalizeNFD:
+		return n, nil
+	case "none":
+		return NormalizeNone, nil
+	default:
+		return "", errors.New(fmt.Sprintf("unknown unicode normalization %s (none|nfc|nfd)", str))
+	}
+}
+
+// Apply normalizes name
+func (n Normalization) Apply(name string) string {
+	switch n {
+	case NormalizeNFC:
+		return norm.NFC.String(name)
+	case NormalizeNFD:
+		return norm.NFD.String(name)
+	default:
+		return name
+	}
+}
@@ -0,0 +1,93 @@
+package sanitize
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"golang.org/x/text/cases"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Resolution defines, what happens if different sources result in the same name
+type Resolution string
+
+const (
+	ResolveFail   Resolution = "fail"   // stop with an error
+	ResolveNumber Resolution = "number" // add a numeric suffix: name-1.ext
+	ResolveHash   Resolution = "hash"   // add a hash of the source: name-1a2b3c4d.ext
+)
+
+// ParseResolution converts a string to a Resolution
+func ParseResolution(str string) (Resolution, error) {
+	switch res := Resolution(strings.ToLower(strings.TrimSpace(str))); res {
+	case ResolveFail, ResolveNumber, ResolveHash:
+		return res, nil
+	default:
+		return "", errors.New(fmt.Sprintf("unknown collision resolution %s (fail|number|hash)", str))
+	}
+}
+
+// Registry remembers the sanitized names and detects different sources,
+// which result in the same name. with case folding, names which differ only
+// in case are collisions too (case-insensitive file systems)
+type Registry struct {
+	sync.Mutex
+	resolution Resolution
+	fold       bool
+	folder     cases.Caser
+	names      map[string]string // (folded) name -> source
+}
+
+func NewRegistry(resolution Resolution, foldCase bool) *Registry {
+	return &Registry{resolution: resolution, fold: foldCase, folder: cases.Fold(), names: map[string]string{}}
+}
+
+func (r *Registry) key(name string) string {
+	if r.fold {
+		return r.folder.String(name)
+	}
+	return name
+}
+
+// suffix adds a suffix to the filename before the extension
+func suffix(name, suffix string) string {
+	ext := path.Ext(name)
+	if ext == path.Base(name) {
+		ext = ""
+	}
+	return strings.TrimSuffix(name, ext) + "-" + suffix + ext
+}
+
+// Register adds a source with its sanitized name and returns the name to use.
+// if the name is already used by another source, it is resolved according to the resolution
+func (r *Registry) Register(source, sanitized string) (string, error) {
+	r.Lock()
+	defer r.Unlock()
+	other, ok := r.names[r.key(sanitized)]
+	if !ok || other == source {
+		r.names[r.key(sanitized)] = source
+		return sanitized, nil
+	}
+	var base string
+	switch r.resolution {
+	case ResolveNumber:
+		base = sanitized
+	case ResolveHash:
+		base = suffix(sanitized, fmt.Sprintf("%x", sha256.Sum256([]byte(source)))[:8])
+		if _, ok := r.names[r.key(base)]; !ok {
+			r.names[r.key(base)] = source
+			return base, nil
+		}
+	default:
+		return "", errors.New(fmt.Sprintf("%s and %s both result in %s", other, source, sanitized))
+	}
+	for i := 1; ; i++ {
+		name := suffix(base, fmt.Sprintf("%d", i))
+		if _, ok := r.names[r.key(name)]; !ok {
+			r.names[r.key(name)] = source
+			return name, nil
+		}
+	}
+}
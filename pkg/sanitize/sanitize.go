@@ -0,0 +1,153 @@
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"github.com/goph/emperror"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Rule replaces all matches of Pattern within a path segment by Replace.
+// Replace may contain submatches like $1
+type Rule struct {
+	Pattern string
+	Replace string
+}
+
+// RuleSet is a named and versioned list of rules, which are applied in order.
+// name and version identify the rules, so that a sanitized name can be reproduced later
+type RuleSet struct {
+	name    string
+	version string
+	rules   []Rule
+	regexps []*regexp.Regexp
+}
+
+// NewRuleSet compiles the rules
+func NewRuleSet(name, version string, rules []Rule) (*RuleSet, error) {
+	rs := &RuleSet{
+		name:    name,
+		version: version,
+		rules:   append([]Rule{}, rules...),
+	}
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, emperror.Wrapf(err, "invalid pattern %s in rules %s", rule.Pattern, name)
+		}
+		rs.regexps = append(rs.regexps, re)
+	}
+	return rs, nil
+}
+
+func (rs *RuleSet) Name() string    { return rs.name }
+func (rs *RuleSet) Version() string { return rs.version }
+func (rs *RuleSet) Rules() []Rule   { return append([]Rule{}, rs.rules...) }
+
+// string representation name/version
+func (rs *RuleSet) String() string {
+	return fmt.Sprintf("%s/%s", rs.name, rs.version)
+}
+
+// Sanitize applies all rules to every segment of a slash separated path.
+// invalid UTF-8 is removed, a leading slash is kept
+func (rs *RuleSet) Sanitize(fname string) string {
+	fname = strings.ToValidUTF8(fname, "")
+
+	names := strings.Split(fname, "/")
+	result := []string{}
+	for _, n := range names {
+		for key, re := range rs.regexps {
+			n = re.ReplaceAllString(n, rs.rules[key].Replace)
+		}
+		result = append(result, n)
+	}
+
+	fname = filepath.ToSlash(filepath.Join(result...))
+	if len(result) > 0 && result[0] == "" {
+		fname = "/" + fname
+	}
+	return fname
+}
+
+// names of the preset rule sets
+const (
+	Windows     = "windows"      // names, which are valid on windows file systems
+	POSIX       = "posix"        // POSIX portable filename character set
+	Wheeler     = "wheeler"      // rules of David A. Wheeler
+	WheelerOCFL = "wheeler-ocfl" // rules of David A. Wheeler, without # for OCFL
+)
+
+type preset struct {
+	version string
+	rules   []Rule
+}
+
+var presets = map[string]preset{
+	Windows: {
+		version: "1",
+		rules: []Rule{
+			{Pattern: "[\x00-\x1F\"*:<>?\\\\|]", Replace: "_"},
+			{Pattern: `^[. ]+$`, Replace: "_"}, // keep segments of dots and spaces
+			{Pattern: `[. ]+$`, Replace: ""},
+			{Pattern: `(?i)^(CON|PRN|AUX|NUL|COM[1-9]|LPT[1-9])(\..*)?$`, Replace: "_$1$2"},
+		},
+	},
+	POSIX: {
+		version: "1",
+		rules: []Rule{
+			{Pattern: `[^A-Za-z0-9._-]`, Replace: "_"},
+			{Pattern: `^-`, Replace: "_"},
+		},
+	},
+	/**********************************************************************
+	 * 1) Forbid/escape ASCII control characters (bytes 1-31 and 127) in filenames, including newline, escape, and tab.
+	 * 2) Forbid/escape leading “-”.
+	 * 3) Forbid/escape filenames that aren’t a valid UTF-8 encoding.
+	 * 4) Forbid/escape leading/trailing space characters — at least trailing spaces.
+	 * 5) Forbid/escape “problematic” characters that get specially interpreted by shells, other interpreters (such as perl),
+	 *    and HTML/XML: “*?:[]"<>|(){}&'!\;”
+	 * 6) Forbid/escape leading “~” (tilde).
+	 *
+	 * https://www.dwheeler.com/essays/fixing-unix-linux-filenames.html
+	 */
+	Wheeler: {
+		version: "1",
+		rules: []Rule{
+			{Pattern: "[\x00-\x1F\x7F\n\r\t*?:\\[\\]\"<>|(){}&'!\\;]", Replace: "_"},
+			{Pattern: "^[\\s\\-~]*(.*?)\\s*$", Replace: "$1"},
+		},
+	},
+	WheelerOCFL: {
+		version: "1",
+		rules: []Rule{
+			{Pattern: "[\x00-\x1F\x7F\n\r\t*?#:\\[\\]\"<>|(){}&'!\\;]", Replace: "_"},
+			{Pattern: "^[\\s\\-~]*(.*?)\\s*$", Replace: "$1"},
+		},
+	},
+}
+
+// Preset returns one of the predefined rule sets
+func Preset(name string) (*RuleSet, error) {
+	p, ok := presets[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown filename rules %s (%s)", name, strings.Join(Presets(), "|")))
+	}
+	return NewRuleSet(strings.ToLower(name), p.version, p.rules)
+}
+
+// MustPreset is like Preset but panics, if the rule set does not exist
+func MustPreset(name string) *RuleSet {
+	rs, err := Preset(name)
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// Presets returns the names of all predefined rule sets
+func Presets() []string {
+	return []string{Windows, POSIX, Wheeler, WheelerOCFL}
+}
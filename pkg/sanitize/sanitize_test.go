@@ -0,0 +1,34 @@
+package sanitize
+
+import "testing"
+
+func TestWindows(t *testing.T) {
+	rs := MustPreset(Windows)
+	for _, test := range []struct {
+		name     string
+		expected string
+	}{
+		{"file.txt", "file.txt"},
+		{"/folder/file.txt", "/folder/file.txt"},
+		{"file.", "file"},
+		{"file. . ", "file"},
+		{"a.b ", "a.b"},
+		{".hidden", ".hidden"},
+		{".", "_"},
+		{"..", "_"},
+		{"...", "_"},
+		{" ", "_"},
+		{". .", "_"},
+		{"/a/../b", "/a/_/b"},
+		{"/a/ /b", "/a/_/b"},
+		{"/a/.../b.", "/a/_/b"},
+		{"a:b?.txt", "a_b_.txt"},
+		{"con", "_con"},
+		{"NUL.txt", "_NUL.txt"},
+		{"LPT1.", "_LPT1"},
+	} {
+		if result := rs.Sanitize(test.name); result != test.expected {
+			t.Errorf("%q: %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}